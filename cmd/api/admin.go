@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/validator"
+)
+
+// adminUserFromParam loads the user named by the "id" URL parameter, sending a 404 if
+// there is no such user.
+func (app *application) adminUserFromParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (app *application) adminListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search    string
+		Activated *bool
+		Filters   data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "q", "")
+	input.Activated = app.readBool(qs, "activated", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(r.Context(), input.Search, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) adminShowUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(r.Context(), user.ID, nil, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions, "sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adminUpdateUserActivatedHandler activates or deactivates a user. A deactivated user
+// can still log in but is refused by every route that needs an activated account.
+func (app *application) adminUpdateUserActivatedHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Activated != nil, "activated", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	before := *user
+	user.Activated = *input.Activated
+
+	action := data.AuditUserActivate
+	if !user.Activated {
+		action = data.AuditUserDeactivate
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: action, ResourceType: "user", ResourceID: auditID(user.ID)}
+		return app.audit(r, tx, event, before, user)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adminResetUserPasswordHandler forces a user to choose a new password. Their current
+// password stops working, every session is revoked, and a password reset token is
+// emailed to them.
+func (app *application) adminResetUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := user.Password.Scramble()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var token *data.Token
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllScopesForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.APIKeys.DeleteAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err = app.issueToken(r, tx, user.ID, 24*time.Hour, data.ScopePasswordReset)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+		err := app.mailer.Send(user.Email, "user_password_reset_required.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "the user's password has been reset and they have been emailed instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) adminDeleteUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeAuthentication, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeRefresh, user.ID)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all sessions revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adminDeleteUserHandler deletes a user in the same way as deleteCurrentUserHandler,
+// leaving the account to be purged after the grace period.
+func (app *application) adminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == app.contextGetUser(r).ID {
+		app.badRequestResponse(w, r, errors.New("use DELETE /v1/users/me to delete your own account"))
+		return
+	}
+
+	var deletedAt time.Time
+
+	err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+		var err error
+
+		deletedAt, err = tx.Users.SoftDelete(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllScopesForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.APIKeys.DeleteAllForUser(r.Context(), user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"message":  "user deleted",
+		"purge_at": deletedAt.Add(app.config.accounts.deletionGrace),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/validator"
+)
+
+// audit records an event in the audit log using models, which should be the transaction
+// making the change so that the two are committed together. The event's changes are
+// worked out from before and after, either of which may be nil. The actor defaults to
+// the authenticated user, and the request ID and client IP are filled in from r.
+func (app *application) audit(r *http.Request, models data.Models, event *data.AuditEvent, before, after interface{}) error {
+	changes, err := data.AuditChanges(before, after)
+	if err != nil {
+		return err
+	}
+
+	event.Changes = changes
+	event.RequestID = app.contextGetRequestID(r)
+	event.ClientIP = app.clientIP(r)
+
+	if event.ActorID == nil {
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			event.ActorID = &user.ID
+		}
+	}
+
+	return models.Audit.Insert(r.Context(), event)
+}
+
+// auditID formats a numeric resource ID for an audit event.
+func auditID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Filter  data.AuditFilter
+		Filters data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	if qs.Get("actor_id") != "" {
+		actorID := int64(app.readInt(qs, "actor_id", 0, v))
+		input.Filter.ActorID = &actorID
+	}
+	input.Filter.Action = app.readString(qs, "action", "")
+	input.Filter.ResourceType = app.readString(qs, "resource_type", "")
+	input.Filter.ResourceID = app.readString(qs, "resource_id", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(r.Context(), input.Filter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -12,6 +12,34 @@ type contextKey string
 // userContextKey is a context key used to store and retrieve user information from a context object.
 const userContextKey = contextKey("user")
 
+// tokenContextKey is a context key used to store the hash of the authentication token
+// presented with the request.
+const tokenContextKey = contextKey("token")
+
+// permissionsContextKey is a context key used to store permissions that arrived with the
+// request itself, such as the claims of a JWT access token.
+const permissionsContextKey = contextKey("permissions")
+
+// apiKeyContextKey is a context key used to store the API key a request was
+// authenticated with.
+const apiKeyContextKey = contextKey("api_key")
+
+// organizationClaimContextKey is a context key used to store the organization named by
+// the claims of a JWT access token.
+const organizationClaimContextKey = contextKey("organization_claim")
+
+// membershipContextKey is a context key used to store the user's membership of the
+// organization the request is acting in.
+const membershipContextKey = contextKey("membership")
+
+// tokenFamilyContextKey is a context key used to store the token family named by a JWT
+// access token.
+const tokenFamilyContextKey = contextKey("token_family")
+
+// requestIDContextKey is a context key used to store the ID that identifies a request in
+// logs and audit events.
+const requestIDContextKey = contextKey("request_id")
+
 // contextSetUser returns a new request with the provided user added to the request's context.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -25,3 +53,104 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetTokenHash returns a new request with the hash of the presented authentication
+// token added to the request's context.
+func (app *application) contextSetTokenHash(r *http.Request, tokenHash []byte) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, tokenHash)
+	return r.WithContext(ctx)
+}
+
+// contextGetTokenHash returns the hash of the presented authentication token, or nil if
+// the request was not authenticated with one.
+func (app *application) contextGetTokenHash(r *http.Request) []byte {
+	tokenHash, _ := r.Context().Value(tokenContextKey).([]byte)
+	return tokenHash
+}
+
+// contextSetPermissions returns a new request with the provided permissions added to the
+// request's context.
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions returns the permissions stored in the request's context, and
+// whether there were any.
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+// contextSetAPIKey returns a new request with the provided API key added to the request's
+// context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or nil if it
+// wasn't authenticated with one.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// contextSetOrganizationClaim returns a new request with the organization ID carried by
+// its access token added to the request's context.
+func (app *application) contextSetOrganizationClaim(r *http.Request, organizationID int64) *http.Request {
+	ctx := context.WithValue(r.Context(), organizationClaimContextKey, organizationID)
+	return r.WithContext(ctx)
+}
+
+// contextGetOrganizationClaim returns the organization ID carried by the request's access
+// token, or 0 if there wasn't one.
+func (app *application) contextGetOrganizationClaim(r *http.Request) int64 {
+	organizationID, _ := r.Context().Value(organizationClaimContextKey).(int64)
+	return organizationID
+}
+
+// contextSetMembership returns a new request with the user's membership of the active
+// organization added to the request's context.
+func (app *application) contextSetMembership(r *http.Request, membership *data.Membership) *http.Request {
+	ctx := context.WithValue(r.Context(), membershipContextKey, membership)
+	return r.WithContext(ctx)
+}
+
+// contextGetMembership returns the user's membership of the active organization. It
+// panics if called on a request that hasn't been through requireOrganization.
+func (app *application) contextGetMembership(r *http.Request) *data.Membership {
+	membership, ok := r.Context().Value(membershipContextKey).(*data.Membership)
+	if !ok {
+		panic("missing membership value in request context")
+	}
+	return membership
+}
+
+// contextSetRequestID returns a new request with the provided request ID added to the
+// request's context.
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the request's ID, or an empty string if it hasn't been
+// through the requestID middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// contextSetTokenFamily returns a new request with the token family of its JWT access
+// token added to the request's context.
+func (app *application) contextSetTokenFamily(r *http.Request, family []byte) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenFamilyContextKey, family)
+	return r.WithContext(ctx)
+}
+
+// contextGetTokenFamily returns the token family of the request's JWT access token, or
+// nil if it wasn't authenticated with one that names a family.
+func (app *application) contextGetTokenFamily(r *http.Request) []byte {
+	family, _ := r.Context().Value(tokenFamilyContextKey).([]byte)
+	return family
+}
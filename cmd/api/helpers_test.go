@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"greenlight.chriss875.net/internal/data"
+)
+
+func TestReadJSONUnknownLength(t *testing.T) {
+	app := newTestApplication(data.Models{})
+
+	tests := []struct {
+		name    string
+		body    string
+		want    int32
+		wantErr error
+	}{
+		{"empty", "", 0, errEmptyBody},
+		{"version", `{"version": 3}`, 3, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A chunked request reports its length as unknown.
+			r := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(tt.body)))
+			r.ContentLength = -1
+
+			var input struct {
+				Version int32 `json:"version"`
+			}
+
+			err := app.readJSON(httptest.NewRecorder(), r, &input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			if input.Version != tt.want {
+				t.Errorf("got version %d; want %d", input.Version, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/validator"
+)
+
+// invitationTTL is how long an invitation can be accepted for. The invitation email
+// template quotes it, so keep the two in step.
+const invitationTTL = 7 * 24 * time.Hour
+
+// createInvitationHandler invites an email address to join an organization and mails the
+// invitation token to it. The address doesn't need to belong to a registered user.
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidateOrgRole(v, input.Role)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	membership, ok := app.organizationMembershipFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !membership.CanManageMembers() || (input.Role == data.OrgRoleOwner && membership.Role != data.OrgRoleOwner) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	inviter, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// There's no point inviting someone who is already a member.
+	invitee, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	switch {
+	case err == nil:
+		_, err = app.models.Organizations.GetMembership(r.Context(), membership.OrganizationID, invitee.ID)
+		switch {
+		case err == nil:
+			v.AddError("email", "this user is already a member of the organization")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	invitation, err := app.models.Invitations.New(r.Context(), membership.OrganizationID, input.Email, input.Role, inviter.ID, invitationTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"invitationToken":  invitation.Plaintext,
+			"organizationName": membership.OrganizationName,
+			"inviterName":      inviter.Name,
+			"role":             invitation.Role,
+		}
+
+		err := app.mailer.Send(invitation.Email, "organization_invitation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listInvitationsHandler shows an organization's pending invitations to its owners and
+// admins.
+func (app *application) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	membership, ok := app.organizationMembershipFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !membership.CanManageMembers() {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	invitations, err := app.models.Invitations.GetAllForOrganization(r.Context(), membership.OrganizationID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invitations": invitations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteInvitationHandler revokes a pending invitation, so that its token can no longer
+// be used.
+func (app *application) deleteInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	invitationID, err := app.readNamedIDParam(r, "invitation_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	membership, ok := app.organizationMembershipFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !membership.CanManageMembers() {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.Invitations.Delete(r.Context(), membership.OrganizationID, invitationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "invitation revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// acceptInvitationHandler adds the current user to the organization they were invited
+// to. The invitation must have been sent to the user's own email address.
+func (app *application) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	invitation, ok := app.invitationForEmail(w, r, v, "token", input.TokenPlaintext, user.Email)
+	if !ok {
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Organizations.AddMember(r.Context(), invitation.OrganizationID, user.ID, invitation.Role)
+		if err != nil {
+			return err
+		}
+
+		return tx.Invitations.Delete(r.Context(), invitation.OrganizationID, invitation.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateMember):
+			v.AddError("token", "you are already a member of this organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	membership, err := app.models.Organizations.GetMembership(r.Context(), invitation.OrganizationID, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": membership}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// invitationForEmail looks up the invitation for a plaintext token and checks that it was
+// sent to the given address, reporting problems as validation errors against key.
+func (app *application) invitationForEmail(w http.ResponseWriter, r *http.Request, v *validator.Validator, key, tokenPlaintext, email string) (*data.Invitation, bool) {
+	invitation, err := app.models.Invitations.GetForToken(r.Context(), tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError(key, "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	// Email addresses are case-insensitive, as they are in the database.
+	if !strings.EqualFold(invitation.Email, email) {
+		v.AddError(key, "this invitation was sent to a different email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return invitation, true
+}
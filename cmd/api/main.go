@@ -3,14 +3,20 @@ package main
 import (
 	"context"
 	"database/sql"
+	"expvar"
 	"flag"
+	"fmt"
+	"math"
 	"os"
 	"sync"
 	"time"
 
+	"greenlight.chriss875.net/internal/breach"
 	"greenlight.chriss875.net/internal/data"
 	"greenlight.chriss875.net/internal/jsonlog"
+	"greenlight.chriss875.net/internal/jwt"
 	"greenlight.chriss875.net/internal/mailer"
+	"greenlight.chriss875.net/internal/passwords"
 
 	_ "github.com/lib/pq"
 )
@@ -25,6 +31,7 @@ type config struct {
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		queryTimeout string
 	}
 
 	limiter struct {
@@ -33,6 +40,41 @@ type config struct {
 		enabled bool
 	}
 
+	tokens struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+
+	passwords struct {
+		algorithm         string
+		argon2Memory      uint
+		argon2Iterations  uint
+		argon2Parallelism uint
+		bcryptCost        int
+		breached          string
+	}
+
+	login struct {
+		accountPolicy data.LockoutPolicy
+		ipPolicy      data.LockoutPolicy
+	}
+
+	roles struct {
+		defaultRole        string
+		permissionCacheTTL time.Duration
+	}
+
+	accounts struct {
+		deletionGrace time.Duration
+		purgeInterval time.Duration
+	}
+
+	auth struct {
+		mode            string
+		jwtKeys         string
+		jwtSigningKeyID string
+	}
+
 	smtp struct {
 		host     string
 		port     int
@@ -43,11 +85,14 @@ type config struct {
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	jwtKeys *jwt.KeySet
+	wg      sync.WaitGroup
+
+	permissionCache *permissionCache
 }
 
 func main() {
@@ -60,11 +105,41 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgresSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgresSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgresSQL max connection idle time")
+	flag.StringVar(&cfg.db.queryTimeout, "db-query-timeout", "3s", "PostgresSQL per-query timeout")
 
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	flag.DurationVar(&cfg.tokens.accessTTL, "token-access-ttl", 15*time.Minute, "Authentication (access) token lifetime")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "token-refresh-ttl", 30*24*time.Hour, "Refresh token lifetime")
+
+	flag.StringVar(&cfg.passwords.algorithm, "password-algorithm", "argon2id", "Algorithm for new password hashes (argon2id|bcrypt)")
+	flag.UintVar(&cfg.passwords.argon2Memory, "argon2-memory", 64*1024, "argon2id memory cost in KiB")
+	flag.UintVar(&cfg.passwords.argon2Iterations, "argon2-iterations", 3, "argon2id time cost")
+	flag.UintVar(&cfg.passwords.argon2Parallelism, "argon2-parallelism", 2, "argon2id parallelism")
+	flag.IntVar(&cfg.passwords.bcryptCost, "bcrypt-cost", 12, "bcrypt cost")
+	flag.StringVar(&cfg.passwords.breached, "breached-passwords", "", "HIBP range directory or bloom filter file of breached passwords to reject")
+
+	flag.IntVar(&cfg.login.accountPolicy.Threshold, "login-max-account-failures", 5, "Failed logins for one email address before it is locked out")
+	flag.IntVar(&cfg.login.ipPolicy.Threshold, "login-max-ip-failures", 50, "Failed logins from one IP address before it is locked out")
+	flag.DurationVar(&cfg.login.accountPolicy.Lockout, "login-lockout", time.Minute, "Initial lockout of an email address after too many failed logins, doubled on each further failure")
+	flag.DurationVar(&cfg.login.accountPolicy.MaxLockout, "login-max-lockout", time.Hour, "Maximum lockout of an email address after too many failed logins")
+	flag.DurationVar(&cfg.login.accountPolicy.Window, "login-failure-window", 24*time.Hour, "How long failed logins for an email address are remembered")
+	flag.DurationVar(&cfg.login.ipPolicy.Lockout, "login-ip-lockout", time.Minute, "Initial lockout of an IP address after too many failed logins, doubled on each further failure")
+	flag.DurationVar(&cfg.login.ipPolicy.MaxLockout, "login-max-ip-lockout", time.Hour, "Maximum lockout of an IP address after too many failed logins")
+	flag.DurationVar(&cfg.login.ipPolicy.Window, "login-ip-failure-window", 24*time.Hour, "How long failed logins from an IP address are remembered")
+
+	flag.StringVar(&cfg.roles.defaultRole, "default-role", "viewer", "Role given to newly registered users")
+	flag.DurationVar(&cfg.roles.permissionCacheTTL, "permission-cache-ttl", 30*time.Second, "How long a user's permissions are cached in memory (0 disables caching)")
+
+	flag.DurationVar(&cfg.accounts.deletionGrace, "account-deletion-grace", 30*24*time.Hour, "How long a deleted account is kept before it is purged")
+	flag.DurationVar(&cfg.accounts.purgeInterval, "account-purge-interval", time.Hour, "How often deleted accounts past their grace period are purged")
+
+	flag.StringVar(&cfg.auth.mode, "auth-mode", authModeOpaque, "Access token format (opaque|jwt)")
+	flag.StringVar(&cfg.auth.jwtKeys, "jwt-keys", os.Getenv("GREENLIGHT_JWT_KEYS"), "JWT keys as comma-separated kid:alg:base64key entries (alg is HS256 or EdDSA)")
+	flag.StringVar(&cfg.auth.jwtSigningKeyID, "jwt-signing-key-id", "", "ID of the JWT key used to sign new tokens")
+
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", "a182641d2864fb", "SMTP username")
@@ -75,6 +150,50 @@ func main() {
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// The argon2id parameters are narrower than the flags that set them, zero iterations
+	// or parallelism would make every hash panic, and a purge interval that isn't positive
+	// would panic the purge goroutine, so check them up front.
+	switch {
+	case cfg.passwords.argon2Iterations < 1 || cfg.passwords.argon2Iterations > math.MaxUint32:
+		logger.PrintFatal(fmt.Errorf("-argon2-iterations must be between 1 and %d", uint32(math.MaxUint32)), nil)
+	case cfg.passwords.argon2Parallelism < 1 || cfg.passwords.argon2Parallelism > math.MaxUint8:
+		logger.PrintFatal(fmt.Errorf("-argon2-parallelism must be between 1 and %d", math.MaxUint8), nil)
+	case cfg.passwords.argon2Memory < 8*cfg.passwords.argon2Parallelism || cfg.passwords.argon2Memory > math.MaxUint32:
+		logger.PrintFatal(fmt.Errorf("-argon2-memory must be between 8 KiB per thread and %d KiB", uint32(math.MaxUint32)), nil)
+	case cfg.passwords.bcryptCost < passwords.BcryptMinCost || cfg.passwords.bcryptCost > passwords.BcryptMaxCost:
+		logger.PrintFatal(fmt.Errorf("-bcrypt-cost must be between %d and %d", passwords.BcryptMinCost, passwords.BcryptMaxCost), nil)
+	case cfg.accounts.purgeInterval <= 0:
+		logger.PrintFatal(fmt.Errorf("-account-purge-interval must be positive"), nil)
+	case cfg.accounts.deletionGrace < 0:
+		logger.PrintFatal(fmt.Errorf("-account-deletion-grace must not be negative"), nil)
+	}
+
+	argon2id := passwords.DefaultArgon2id()
+	argon2id.Memory = uint32(cfg.passwords.argon2Memory)
+	argon2id.Iterations = uint32(cfg.passwords.argon2Iterations)
+	argon2id.Parallelism = uint8(cfg.passwords.argon2Parallelism)
+	bcrypt := passwords.Bcrypt{Cost: cfg.passwords.bcryptCost}
+
+	// Both algorithms are always available for verifying existing hashes; the flag only
+	// chooses which one new hashes use.
+	switch cfg.passwords.algorithm {
+	case "argon2id":
+		data.PasswordHashers = passwords.NewSet(argon2id, bcrypt)
+	case "bcrypt":
+		data.PasswordHashers = passwords.NewSet(bcrypt, argon2id)
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid password algorithm %q", cfg.passwords.algorithm), nil)
+	}
+
+	if cfg.passwords.breached != "" {
+		checker, err := breach.Open(cfg.passwords.breached)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		data.BreachedPasswords = loggedBreachChecker{Checker: checker, logger: logger}
+		logger.PrintInfo("breached password check enabled", map[string]string{"source": cfg.passwords.breached})
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -84,13 +203,45 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	queryTimeout, err := time.ParseDuration(cfg.db.queryTimeout)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
+		models: data.NewModels(db, queryTimeout),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+
+		permissionCache: newPermissionCache(cfg.roles.permissionCacheTTL),
+	}
+
+	expvar.Publish("permission_cache", expvar.Func(func() interface{} {
+		return app.permissionCache.Metrics()
+	}))
+
+	exists, err := app.models.Roles.Exists(context.Background(), cfg.roles.defaultRole)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	if !exists {
+		logger.PrintFatal(fmt.Errorf("default role %q does not exist", cfg.roles.defaultRole), nil)
+	}
+
+	switch cfg.auth.mode {
+	case authModeOpaque:
+	case authModeJWT:
+		app.jwtKeys, err = jwt.ParseKeySet(cfg.auth.jwtKeys, cfg.auth.jwtSigningKeyID)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid auth mode %q", cfg.auth.mode), nil)
 	}
 
+	go app.purgeDeletedUsers()
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -129,3 +280,18 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// loggedBreachChecker logs the errors of a breached password check. The password is
+// refused when its check fails, so this is how operators find out the corpus is broken.
+type loggedBreachChecker struct {
+	breach.Checker
+	logger *jsonlog.Logger
+}
+
+func (c loggedBreachChecker) Contains(plaintext string) (bool, error) {
+	breached, err := c.Checker.Contains(plaintext)
+	if err != nil {
+		c.logger.PrintError(err, nil)
+	}
+	return breached, err
+}
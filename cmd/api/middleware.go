@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -28,6 +33,35 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// requestIDPattern matches the request IDs accepted from clients and proxies. Anything
+// else is replaced, so that stored IDs are always safe to log and display.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestID gives every request an ID, reusing a well-formed X-Request-ID header from
+// the client or a proxy in front of the API, and echoes it in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+
+		if !requestIDPattern.MatchString(requestID) {
+			b := make([]byte, 16)
+
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			requestID = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+		r = app.contextSetRequestID(r, requestID)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) rateLimit(next http.Handler) http.Handler {
 	// client struct to hold the rate limiter and last seen time for each client.
 	type client struct {
@@ -93,26 +127,135 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 	})
 }
 
+// sessionTouchInterval is the minimum time between two writes of the last-used
+// metadata for the same authentication token.
+const sessionTouchInterval = time.Minute
+
 func (app *application) authenticate(next http.Handler) http.Handler {
+	// lastTouched records when each token or API key last had its usage metadata written,
+	// so that a busy client only causes one write per interval.
+	var (
+		mu          sync.Mutex
+		lastTouched = make(map[string]time.Time)
+	)
+
+	// Launch a background goroutine to forget credentials that haven't been seen recently.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			mu.Lock()
+			for key, touched := range lastTouched {
+				if time.Since(touched) > sessionTouchInterval {
+					delete(lastTouched, key)
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	// shouldTouch reports whether the usage metadata for the given credential is due to
+	// be written, and if so records that it is being written now.
+	shouldTouch := func(key string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if time.Since(lastTouched[key]) < sessionTouchInterval {
+			return false
+		}
+		lastTouched[key] = time.Now()
+		return true
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		// Check if the request has an authorization header.
+		// Check if the request has an authorization header or an API key header.
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+		w.Header().Add("Vary", "X-Organization-ID")
 
 		authorizationHeader := r.Header.Get("Authorization")
-		if authorizationHeader == "" {
+		apiKey := r.Header.Get("X-API-Key")
+
+		if authorizationHeader == "" && apiKey == "" {
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
+		var token string
+
+		if authorizationHeader != "" {
+			headerParts := strings.Split(authorizationHeader, " ")
+			if len(headerParts) != 2 {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			switch {
+			case headerParts[0] == "Bearer" && apiKey == "":
+				token = headerParts[1]
+			case headerParts[0] == "ApiKey" && apiKey == "":
+				apiKey = headerParts[1]
+			default:
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+		}
+
+		// API keys are looked up by their prefix and checked against the stored hash.
+		if apiKey != "" {
+			v := validator.New()
+
+			if data.ValidateAPIKeyPlaintext(v, apiKey); !v.Valid() {
+				app.invalidAPIKeyResponse(w, r)
+				return
+			}
+
+			key, user, err := app.models.APIKeys.GetForPlaintext(r.Context(), apiKey)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAPIKeyResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, key)
+
+			if shouldTouch("api_key:" + key.Prefix) {
+				app.background(func() {
+					err := app.models.APIKeys.Touch(context.Background(), key.ID)
+					if err != nil {
+						app.logger.PrintError(err, nil)
+					}
+				})
+			}
+
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		token := headerParts[1]
+		// In JWT mode, signed access tokens are authenticated without touching the
+		// database. Opaque tokens are still accepted below.
+		if app.jwtKeys != nil && isJWT(token) {
+			user, claims, err := app.userFromJWT(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, data.Permissions(claims.Permissions))
+			r = app.contextSetOrganizationClaim(r, claims.Organization)
+			r = app.contextSetTokenFamily(r, jwtFamily(claims))
+
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		v := validator.New()
 
@@ -121,7 +264,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -133,14 +276,53 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		tokenHash := data.TokenHash(token)
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetTokenHash(r, tokenHash)
+
+		// Record when and from where the token was last used, at most once per interval.
+		if shouldTouch("token:" + string(tokenHash)) {
+			clientIP, userAgent := app.clientIP(r), r.UserAgent()
+
+			// The request context is cancelled once the response is written, so the
+			// write runs with its own context.
+			app.background(func() {
+				err := app.models.Tokens.Touch(context.Background(), tokenHash, clientIP, userAgent)
+				if err != nil {
+					app.logger.PrintError(err, nil)
+				}
+			})
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-// requireAuthenticatedUser is a middleware that checks if the user is authenticated.
+// requireAuthenticatedUser is a middleware that checks if the user is authenticated. It
+// guards routes that act on the user's own account and organizations, which no
+// permission covers, so requests made with a scoped API key are refused.
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := app.contextGetAPIKey(r); key != nil && key.IsScoped() {
+			app.scopedAPIKeyResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthentication(fn)
+}
+
+// requireActivatedUser is a middleware that checks if the user is activated. Like
+// requireAuthenticatedUser, it refuses scoped API keys.
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return app.requireAuthenticatedUser(app.requireActivation(next))
+}
+
+// requireAuthentication checks that the request is authenticated, with any credential.
+func (app *application) requireAuthentication(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
@@ -153,9 +335,10 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
-// requireActivatedUser is a middleware that checks if the user is activated.
-func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
-	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// requireActivation checks that the authenticated user is activated. It must be used
+// inside requireAuthentication.
+func (app *application) requireActivation(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
 		if !user.Activated {
@@ -165,32 +348,106 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 		next.ServeHTTP(w, r)
 	})
+}
+
+// hasPermission reports whether the current request's user holds the given permission.
+// Permissions carried by the access token itself are preferred, and a scoped API key is
+// limited to the permissions it was granted, even if its owner has more.
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	user := app.contextGetUser(r)
+
+	permissions, ok := app.contextGetPermissions(r)
+	if !ok {
+		var err error
+		permissions, err = app.permissionCache.Get(r.Context(), user.ID, app.models.Permissions.GetAllForUser)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if !permissions.Include(code) {
+		return false, nil
+	}
+
+	if key := app.contextGetAPIKey(r); key != nil && key.IsScoped() && !key.Permissions.Include(code) {
+		return false, nil
+	}
 
-	return app.requireAuthenticatedUser(fn)
+	return true, nil
 }
 
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.requireAnyPermission([]string{code}, next)
+}
+
+// requireAnyPermission lets the request through if the user holds at least one of the
+// given permissions. Handlers that accept more than one can use hasPermission to tell
+// which.
+func (app *application) requireAnyPermission(codes []string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		// Retrieve the user from the request context.
+		for _, code := range codes {
+			ok, err := app.hasPermission(r, code)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		app.notPermittedResponse(w, r)
+	}
+	// Scoped API keys are allowed through, since hasPermission applies their scope.
+	return app.requireAuthentication(app.requireActivation(fn))
+}
+
+// requireOrganization resolves the organization the request acts in and checks that the
+// user belongs to it. The organization is taken from the X-Organization-ID header, then
+// from the access token's claims, and otherwise defaults to the first one the user
+// joined. It must be used inside requireAuthenticatedUser or one of the permission
+// checks.
+func (app *application) requireOrganization(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
-		// Get the slice of permissions for the user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+
+		organizationID := app.contextGetOrganizationClaim(r)
+
+		if header := r.Header.Get("X-Organization-ID"); header != "" {
+			id, err := strconv.ParseInt(header, 10, 64)
+			if err != nil || id < 1 {
+				app.badRequestResponse(w, r, errors.New("invalid X-Organization-ID header"))
+				return
+			}
+			organizationID = id
 		}
-		// Check if the slice includes the required permission. If it doesn't, then
-		// return a 403 Forbidden response.
-		if !permissions.Include(code) {
-			app.notPermittedResponse(w, r)
+
+		var membership *data.Membership
+		var err error
+
+		if organizationID != 0 {
+			membership, err = app.models.Organizations.GetMembership(r.Context(), organizationID, user.ID)
+		} else {
+			membership, err = app.models.Organizations.GetDefaultForUser(r.Context(), user.ID)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound) && organizationID != 0:
+				app.notOrganizationMemberResponse(w, r)
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.organizationRequiredResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
 			return
 		}
-		// Otherwise they have the required permission, so we call the next handler in
-		// the chain.
+
+		r = app.contextSetMembership(r, membership)
+
 		next.ServeHTTP(w, r)
 	}
-	// Wrap this with the requireActivatedUser() middleware before returning it.
-	return app.requireActivatedUser(fn)
 }
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
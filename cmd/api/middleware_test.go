@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/jsonlog"
+	"greenlight.chriss875.net/internal/testdb"
+)
+
+func newTestApplication(models data.Models) *application {
+	return &application{
+		logger: jsonlog.New(io.Discard, jsonlog.LevelFatal),
+		models: models,
+	}
+}
+
+// serveRequireOrganization runs a request as user through requireOrganization, and
+// returns the response and the membership the wrapped handler saw, if it was reached.
+func serveRequireOrganization(app *application, user *data.User, header string) (*httptest.ResponseRecorder, *data.Membership) {
+	var membership *data.Membership
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		membership = app.contextGetMembership(r)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	if header != "" {
+		r.Header.Set("X-Organization-ID", header)
+	}
+	r = app.contextSetUser(r, user)
+
+	rr := httptest.NewRecorder()
+	app.requireOrganization(next).ServeHTTP(rr, r)
+
+	return rr, membership
+}
+
+func TestRequireOrganizationInvalidHeader(t *testing.T) {
+	app := newTestApplication(data.Models{})
+	user := &data.User{ID: 1, Activated: true}
+
+	for _, header := range []string{"abc", "0", "-1"} {
+		rr, membership := serveRequireOrganization(app, user, header)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("X-Organization-ID %q: got status %d; want %d", header, rr.Code, http.StatusBadRequest)
+		}
+		if membership != nil {
+			t.Errorf("X-Organization-ID %q: handler was called", header)
+		}
+	}
+}
+
+func TestRequireOrganizationMembership(t *testing.T) {
+	ctx := context.Background()
+	models := data.NewModels(testdb.New(t), 5*time.Second)
+	app := newTestApplication(models)
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orgA := &data.Organization{Name: "A"}
+	orgB := &data.Organization{Name: "B"}
+
+	for _, org := range []*data.Organization{orgA, orgB} {
+		err := models.Organizations.Insert(ctx, org)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = models.Organizations.AddMember(ctx, orgA.ID, user.ID, data.OrgRoleEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("other organization", func(t *testing.T) {
+		rr, membership := serveRequireOrganization(app, user, strconv.FormatInt(orgB.ID, 10))
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("got status %d; want %d", rr.Code, http.StatusForbidden)
+		}
+		if membership != nil {
+			t.Errorf("handler was called with organization %d", membership.OrganizationID)
+		}
+	})
+
+	t.Run("missing organization", func(t *testing.T) {
+		rr, membership := serveRequireOrganization(app, user, strconv.FormatInt(orgB.ID+1000, 10))
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("got status %d; want %d", rr.Code, http.StatusForbidden)
+		}
+		if membership != nil {
+			t.Errorf("handler was called with organization %d", membership.OrganizationID)
+		}
+	})
+
+	t.Run("own organization", func(t *testing.T) {
+		_, membership := serveRequireOrganization(app, user, strconv.FormatInt(orgA.ID, 10))
+
+		if membership == nil || membership.OrganizationID != orgA.ID {
+			t.Fatalf("got membership %+v; want organization %d", membership, orgA.ID)
+		}
+	})
+
+	t.Run("default organization", func(t *testing.T) {
+		_, membership := serveRequireOrganization(app, user, "")
+
+		if membership == nil || membership.OrganizationID != orgA.ID {
+			t.Fatalf("got membership %+v; want organization %d", membership, orgA.ID)
+		}
+	})
+}
+
+func TestRequireAuthenticatedUserScopedAPIKey(t *testing.T) {
+	app := newTestApplication(data.Models{})
+	user := &data.User{ID: 1, Activated: true}
+
+	tests := []struct {
+		name       string
+		key        *data.APIKey
+		wantStatus int
+	}{
+		{"no key", nil, http.StatusOK},
+		{"unscoped key", &data.APIKey{}, http.StatusOK},
+		{"scoped key", &data.APIKey{Permissions: data.Permissions{"movies:read"}}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, middleware := range []func(http.HandlerFunc) http.HandlerFunc{app.requireAuthenticatedUser, app.requireActivatedUser} {
+				r := httptest.NewRequest(http.MethodGet, "/v1/users/me", nil)
+				r = app.contextSetUser(r, user)
+				if tt.key != nil {
+					r = app.contextSetAPIKey(r, tt.key)
+				}
+
+				rr := httptest.NewRecorder()
+				middleware(func(w http.ResponseWriter, r *http.Request) {}).ServeHTTP(rr, r)
+
+				if rr.Code != tt.wantStatus {
+					t.Errorf("got status %d; want %d", rr.Code, tt.wantStatus)
+				}
+			}
+		})
+	}
+}
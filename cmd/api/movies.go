@@ -9,6 +9,30 @@ import (
 	"greenlight.chriss875.net/internal/validator"
 )
 
+// movieWritePermissions are the permissions that allow changes to movies. "movies:write"
+// covers every movie, while "movies:write:own" only covers those the user created.
+var movieWritePermissions = []string{"movies:write", "movies:write:own"}
+
+// canWriteMovie reports whether the current user may change or delete the given movie.
+// Their role in the organization must allow writes as well as their permissions.
+func (app *application) canWriteMovie(r *http.Request, movie *data.Movie) (bool, error) {
+	if !app.contextGetMembership(r).CanWriteMovies() {
+		return false, nil
+	}
+
+	ok, err := app.hasPermission(r, "movies:write")
+	if err != nil || ok {
+		return ok, err
+	}
+
+	user := app.contextGetUser(r)
+	if movie.CreatedBy == nil || *movie.CreatedBy != user.ID {
+		return false, nil
+	}
+
+	return app.hasPermission(r, "movies:write:own")
+}
+
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Title   string       `json:"title"`
@@ -23,11 +47,21 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	user := app.contextGetUser(r)
+	membership := app.contextGetMembership(r)
+
+	if !membership.CanWriteMovies() {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:          input.Title,
+		Year:           input.Year,
+		Runtime:        input.Runtime,
+		Genres:         input.Genres,
+		CreatedBy:      &user.ID,
+		OrganizationID: membership.OrganizationID,
 	}
 
 	v := validator.New()
@@ -37,9 +71,23 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Movies.Insert(r.Context(), movie)
+		if err != nil {
+			return err
+		}
+
+		err = tx.MovieRevisions.Insert(r.Context(), movie, &user.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditMovieCreate, ResourceType: "movie", ResourceID: auditID(movie.ID)}
+		return app.audit(r, tx, event, nil, movie)
+	})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	headers := make(http.Header)
@@ -59,7 +107,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), app.contextGetMembership(r).OrganizationID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -84,7 +132,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Retrieve the movie from the database.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), app.contextGetMembership(r).OrganizationID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -95,6 +143,17 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	ok, err := app.canWriteMovie(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Title   *string       `json:"title"`
 		Year    *int32        `json:"year"`
@@ -109,6 +168,9 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Keep a copy of the movie as it was, for the audit log.
+	before := *movie
+
 	if input.Title != nil {
 		movie.Title = *input.Title
 	}
@@ -131,8 +193,23 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Update the movie in the database.
-	err = app.models.Movies.Update(movie)
+	user := app.contextGetUser(r)
+
+	// Update the movie in the database, keeping the new version as a revision.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Movies.Update(r.Context(), movie)
+		if err != nil {
+			return err
+		}
+
+		err = tx.MovieRevisions.Insert(r.Context(), movie, &user.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditMovieUpdate, ResourceType: "movie", ResourceID: auditID(movie.ID)}
+		return app.audit(r, tx, event, before, movie)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -157,8 +234,38 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	movie, err := app.models.Movies.Get(r.Context(), app.contextGetMembership(r).OrganizationID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := app.canWriteMovie(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	// Delete the movie from the database.
-	err = app.models.Movies.Delete(id)
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Movies.Delete(r.Context(), movie.OrganizationID, movie.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditMovieDelete, ResourceType: "movie", ResourceID: auditID(movie.ID)}
+		return app.audit(r, tx, event, movie, nil)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -201,7 +308,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Retrieve the movies from the database.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), app.contextGetMembership(r).OrganizationID, input.Title, input.Genres, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
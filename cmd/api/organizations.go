@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/validator"
+)
+
+// organizationMembershipFromParam loads the current user's membership of the
+// organization named by the "id" URL parameter. Organizations the user doesn't belong to
+// get the same 404 as ones that don't exist.
+func (app *application) organizationMembershipFromParam(w http.ResponseWriter, r *http.Request) (*data.Membership, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	membership, err := app.models.Organizations.GetMembership(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return membership, true
+}
+
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	organization := &data.Organization{Name: input.Name}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, organization); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// The creator becomes the organization's first owner.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Organizations.Insert(r.Context(), organization)
+		if err != nil {
+			return err
+		}
+
+		return tx.Organizations.AddMember(r.Context(), organization.ID, user.ID, data.OrgRoleOwner)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": organization}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	memberships, err := app.models.Organizations.GetAllForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organizations": memberships}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listOrganizationMembersHandler(w http.ResponseWriter, r *http.Request) {
+	membership, ok := app.organizationMembershipFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := app.models.Organizations.GetMembers(r.Context(), membership.OrganizationID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addOrganizationMemberHandler adds an existing user to an organization by their email
+// address. Only owners can add other owners.
+func (app *application) addOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidateOrgRole(v, input.Role)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	membership, ok := app.organizationMembershipFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !membership.CanManageMembers() || (input.Role == data.OrgRoleOwner && membership.Role != data.OrgRoleOwner) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Organizations.AddMember(r.Context(), membership.OrganizationID, user.ID, input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateMember):
+			v.AddError("email", "this user is already a member of the organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeOrganizationMembers(w, r, http.StatusCreated, membership.OrganizationID)
+}
+
+// updateOrganizationMemberHandler changes a member's role. Only owners can change the
+// role of an owner or make someone an owner, and the last owner can't be demoted.
+func (app *application) updateOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrgRole(v, input.Role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	membership, ok := app.organizationMembershipFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	target, ok := app.organizationMemberForChange(w, r, membership, userID)
+	if !ok {
+		return
+	}
+
+	if input.Role == data.OrgRoleOwner && membership.Role != data.OrgRoleOwner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.Organizations.UpdateMemberRole(r.Context(), membership.OrganizationID, target.UserID, input.Role)
+	if err != nil {
+		app.memberChangeErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeOrganizationMembers(w, r, http.StatusOK, membership.OrganizationID)
+}
+
+// removeOrganizationMemberHandler takes a member out of an organization. Any member can
+// remove themselves; removing anyone else follows the same rules as changing their role.
+func (app *application) removeOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	membership, ok := app.organizationMembershipFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	if userID != membership.UserID {
+		_, ok := app.organizationMemberForChange(w, r, membership, userID)
+		if !ok {
+			return
+		}
+	}
+
+	err = app.models.Organizations.RemoveMember(r.Context(), membership.OrganizationID, userID)
+	if err != nil {
+		app.memberChangeErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "member removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// organizationMemberForChange loads the member with the given user ID and checks that the
+// acting member is allowed to change them.
+func (app *application) organizationMemberForChange(w http.ResponseWriter, r *http.Request, actor *data.Membership, userID int64) (*data.Membership, bool) {
+	if !actor.CanManageMembers() {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	target, err := app.models.Organizations.GetMembership(r.Context(), actor.OrganizationID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if target.Role == data.OrgRoleOwner && actor.Role != data.OrgRoleOwner {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return target, true
+}
+
+func (app *application) memberChangeErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrLastOwner):
+		v := validator.New()
+		v.AddError("role", "an organization must keep at least one owner")
+		app.failedValidationResponse(w, r, v.Errors)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) writeOrganizationMembers(w http.ResponseWriter, r *http.Request, status int, organizationID int64) {
+	members, err := app.models.Organizations.GetMembers(r.Context(), organizationID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, status, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"greenlight.chriss875.net/internal/data"
+)
+
+// permissionCacheMaxEntries bounds the cache. Expired entries are swept out when it is
+// reached, and if that isn't enough the cache is emptied.
+const permissionCacheMaxEntries = 10_000
+
+type permissionCacheEntry struct {
+	permissions data.Permissions
+	expiry      time.Time
+}
+
+// permissionCache keeps each user's permissions in memory for a short time, so that
+// requirePermission doesn't need a query on every request. Anything that changes a
+// user's permissions or roles must call Invalidate or InvalidateAll once the change has
+// been committed. Other instances of the API only see the change once the TTL expires.
+type permissionCache struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	entries    map[int64]permissionCacheEntry
+	generation uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// newPermissionCache returns a cache holding entries for ttl. A ttl of zero disables
+// caching.
+func newPermissionCache(ttl time.Duration) *permissionCache {
+	return &permissionCache{
+		ttl:     ttl,
+		entries: make(map[int64]permissionCacheEntry),
+	}
+}
+
+// Get returns a user's permissions, loading them with load if they aren't cached.
+func (c *permissionCache) Get(ctx context.Context, userID int64, load func(ctx context.Context, userID int64) (data.Permissions, error)) (data.Permissions, error) {
+	if c.ttl <= 0 {
+		return load(ctx, userID)
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	generation := c.generation
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expiry) {
+		c.hits.Add(1)
+		return entry.permissions, nil
+	}
+
+	c.misses.Add(1)
+
+	permissions, err := load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Don't store the result if anything was invalidated while it was loading, as it may
+	// already be out of date.
+	if c.generation != generation {
+		return permissions, nil
+	}
+
+	if len(c.entries) >= permissionCacheMaxEntries {
+		c.sweep()
+	}
+
+	c.entries[userID] = permissionCacheEntry{permissions: permissions, expiry: time.Now().Add(c.ttl)}
+
+	return permissions, nil
+}
+
+// sweep removes expired entries, emptying the cache if it is still full. The caller must
+// hold c.mu.
+func (c *permissionCache) sweep() {
+	now := time.Now()
+
+	for userID, entry := range c.entries {
+		if !now.Before(entry.expiry) {
+			delete(c.entries, userID)
+		}
+	}
+
+	if len(c.entries) >= permissionCacheMaxEntries {
+		c.entries = make(map[int64]permissionCacheEntry)
+	}
+}
+
+// Invalidate drops a single user's cached permissions.
+func (c *permissionCache) Invalidate(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+	c.generation++
+}
+
+// InvalidateAll drops every cached entry, for changes such as a role's permissions being
+// edited that can affect any number of users.
+func (c *permissionCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[int64]permissionCacheEntry)
+	c.generation++
+}
+
+// Metrics returns the cache's hit and miss counts and hit rate, for expvar.
+func (c *permissionCache) Metrics() map[string]interface{} {
+	hits, misses := c.hits.Load(), c.misses.Load()
+
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+
+	c.mu.Lock()
+	size := len(c.entries)
+	c.mu.Unlock()
+
+	return map[string]interface{}{
+		"hits":     hits,
+		"misses":   misses,
+		"hit_rate": hitRate,
+		"entries":  size,
+	}
+}
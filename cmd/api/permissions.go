@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/validator"
+)
+
+// checkPermissionCodesExist adds a validation error under key if any of codes isn't a
+// known permission code.
+func (app *application) checkPermissionCodesExist(r *http.Request, v *validator.Validator, key string, codes []string) error {
+	known, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		return err
+	}
+
+	knownCodes := make([]string, len(known))
+	for i, permission := range known {
+		knownCodes[i] = permission.Code
+	}
+
+	for _, code := range codes {
+		v.Check(validator.In(code, knownCodes...), key, "must only contain existing permission codes")
+	}
+
+	return nil
+}
+
+// checkPermissionCodesHeld adds a validation error under key if the current user doesn't
+// hold every one of codes, so that nobody can grant more than they have themselves. A
+// wildcard is only held through an equal or wider one, so granting "*" requires "*".
+func (app *application) checkPermissionCodesHeld(r *http.Request, v *validator.Validator, key string, codes []string) error {
+	for _, code := range codes {
+		ok, err := app.hasPermission(r, code)
+		if err != nil {
+			return err
+		}
+		v.Check(ok, key, "must not contain permissions you do not hold")
+	}
+
+	return nil
+}
+
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	permission := &data.Permission{Code: input.Code}
+
+	v := validator.New()
+
+	if data.ValidatePermissionCode(v, permission.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Permissions.Insert(r.Context(), permission)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditPermissionCreate, ResourceType: "permission", ResourceID: auditID(permission.ID)}
+		return app.audit(r, tx, event, nil, permission)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePermission):
+			v.AddError("code", "a permission with this code already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"permission": permission}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantUserPermissionsHandler grants one or more existing permission codes to a user.
+// Codes the user already holds are left alone.
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Codes) > 0, "codes", "must contain at least 1 permission code")
+	v.Check(validator.Unique(input.Codes), "codes", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.checkPermissionCodesExist(r, v, "codes", input.Codes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.checkPermissionCodesHeld(r, v, "codes", input.Codes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var permissions data.Permissions
+
+	// Record the user's effective permissions before and after the grant.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		before, err := tx.Permissions.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Permissions.AddForUser(r.Context(), user.ID, input.Codes...)
+		if err != nil {
+			return err
+		}
+
+		permissions, err = tx.Permissions.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditPermissionGrant, ResourceType: "user", ResourceID: auditID(user.ID)}
+		return app.audit(r, tx, event, envelope{"permissions": before}, envelope{"permissions": permissions})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.permissionCache.Invalidate(user.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	var permissions data.Permissions
+
+	// Record the user's effective permissions before and after the revoke.
+	err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+		before, err := tx.Permissions.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Permissions.RemoveForUser(r.Context(), user.ID, code)
+		if err != nil {
+			return err
+		}
+
+		permissions, err = tx.Permissions.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditPermissionRevoke, ResourceType: "user", ResourceID: auditID(user.ID)}
+		return app.audit(r, tx, event, envelope{"permissions": before}, envelope{"permissions": permissions})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.permissionCache.Invalidate(user.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
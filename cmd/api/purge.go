@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"greenlight.chriss875.net/internal/data"
+)
+
+// purgeDeletedUsers runs for the life of the process, permanently removing accounts whose
+// deletion grace period has passed.
+func (app *application) purgeDeletedUsers() {
+	ticker := time.NewTicker(app.config.accounts.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		app.purgeDeletedUsersOnce()
+		<-ticker.C
+	}
+}
+
+func (app *application) purgeDeletedUsersOnce() {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	var purged int64
+
+	err := app.models.WithTx(context.Background(), func(tx data.Models) error {
+		var err error
+		purged, err = tx.Users.PurgeDeleted(context.Background(), time.Now().Add(-app.config.accounts.deletionGrace))
+		return err
+	})
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	if purged > 0 {
+		app.logger.PrintInfo("purged deleted users", map[string]string{"count": strconv.FormatInt(purged, 10)})
+	}
+}
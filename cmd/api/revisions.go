@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/validator"
+)
+
+// movieFromParam loads the movie named by the "id" URL parameter from the active
+// organization.
+func (app *application) movieFromParam(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), app.contextGetMembership(r).OrganizationID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return movie, true
+}
+
+// movieRevisionFromParam loads the revision of movie named by the "version" URL
+// parameter.
+func (app *application) movieRevisionFromParam(w http.ResponseWriter, r *http.Request, movie *data.Movie) (*data.MovieRevision, bool) {
+	version, err := app.readNamedIDParam(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	revision, err := app.models.MovieRevisions.Get(r.Context(), movie.OrganizationID, movie.ID, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return revision, true
+}
+
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Filters data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-version")
+
+	input.Filters.SortSafelist = []string{"version", "-version"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, ok := app.movieFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, metadata, err := app.models.MovieRevisions.GetAll(r.Context(), movie.OrganizationID, movie.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	revision, ok := app.movieRevisionFromParam(w, r, movie)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreMovieRevisionHandler puts a movie's content back to how it was at an earlier
+// version. The restore is saved as a new version rather than rewriting history, and the
+// client can send the version it expects the movie to be at, which is checked in the
+// same way as an update.
+func (app *application) restoreMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Version *int32 `json:"version"`
+	}
+
+	// The body is optional. Its length isn't always known up front, so an empty one is
+	// only found by reading it.
+	err := app.readJSON(w, r, &input)
+	if err != nil && !errors.Is(err, errEmptyBody) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie, ok := app.movieFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	ok, err = app.canWriteMovie(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	revision, ok := app.movieRevisionFromParam(w, r, movie)
+	if !ok {
+		return
+	}
+
+	if input.Version != nil && *input.Version != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	before := *movie
+
+	movie.Title = revision.Title
+	movie.Year = revision.Year
+	movie.Runtime = revision.Runtime
+	movie.Genres = revision.Genres
+
+	user := app.contextGetUser(r)
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Movies.Update(r.Context(), movie)
+		if err != nil {
+			return err
+		}
+
+		err = tx.MovieRevisions.Insert(r.Context(), movie, &user.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditMovieRestore, ResourceType: "movie", ResourceID: auditID(movie.ID)}
+		return app.audit(r, tx, event, before, movie)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/validator"
+)
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        input.Name,
+		Permissions: input.Permissions,
+	}
+
+	if role.Permissions == nil {
+		role.Permissions = data.Permissions{}
+	}
+
+	v := validator.New()
+
+	data.ValidateRole(v, role)
+
+	err = app.checkPermissionCodesExist(r, v, "permissions", role.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.checkPermissionCodesHeld(r, v, "permissions", role.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Roles.Insert(r.Context(), role)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Roles.SetPermissions(r.Context(), role.ID, role.Permissions...)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditRoleCreate, ResourceType: "role", ResourceID: auditID(role.ID)}
+		return app.audit(r, tx, event, nil, role)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRole):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateRolePermissionsHandler replaces the permissions of a role. The change applies to
+// every user with the role.
+func (app *application) updateRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	role, err := app.models.Roles.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	before := envelope{"permissions": role.Permissions}
+
+	// Only the codes being added need checking; the caller may still drop ones they
+	// don't hold.
+	var added []string
+	for _, code := range input.Permissions {
+		if !validator.In(code, role.Permissions...) {
+			added = append(added, code)
+		}
+	}
+
+	role.Permissions = input.Permissions
+	if role.Permissions == nil {
+		role.Permissions = data.Permissions{}
+	}
+
+	v := validator.New()
+
+	data.ValidateRole(v, role)
+
+	err = app.checkPermissionCodesExist(r, v, "permissions", role.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.checkPermissionCodesHeld(r, v, "permissions", added)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Changing a role's permissions grants or revokes them for everyone with the role.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Roles.SetPermissions(r.Context(), role.ID, role.Permissions...)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditRoleUpdate, ResourceType: "role", ResourceID: auditID(role.ID)}
+		return app.audit(r, tx, event, before, envelope{"permissions": role.Permissions})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.permissionCache.InvalidateAll()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	role, err := app.models.Roles.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// New users are given the default role, so it can't be deleted while it's in use.
+	if role.Name == app.config.roles.defaultRole {
+		v := validator.New()
+		v.AddError("role", "is the default role for new users and cannot be deleted")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Deleting a role revokes its permissions from everyone who had it.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Roles.Delete(r.Context(), role.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditRoleDelete, ResourceType: "role", ResourceID: auditID(role.ID)}
+		return app.audit(r, tx, event, role, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.permissionCache.InvalidateAll()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) assignUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Roles) > 0, "roles", "must contain at least 1 role")
+	v.Check(validator.Unique(input.Roles), "roles", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	all, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	known := make(map[string]*data.Role, len(all))
+	for _, role := range all {
+		known[role.Name] = role
+	}
+
+	// Assigning a role grants its permissions, so the caller must hold all of them.
+	for _, name := range input.Roles {
+		role, exists := known[name]
+		v.Check(exists, "roles", "must only contain existing roles")
+
+		if exists {
+			err = app.checkPermissionCodesHeld(r, v, "roles", role.Permissions)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var roles []string
+
+	// Assigning a role grants its permissions, so it is audited like a permission grant.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		before, err := tx.Roles.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Roles.AddForUser(r.Context(), user.ID, input.Roles...)
+		if err != nil {
+			return err
+		}
+
+		roles, err = tx.Roles.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditRoleAssign, ResourceType: "user", ResourceID: auditID(user.ID)}
+		return app.audit(r, tx, event, envelope{"roles": before}, envelope{"roles": roles})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.permissionCache.Invalidate(user.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("role")
+
+	user, ok := app.adminUserFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	var roles []string
+
+	err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+		before, err := tx.Roles.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Roles.RemoveForUser(r.Context(), user.ID, name)
+		if err != nil {
+			return err
+		}
+
+		roles, err = tx.Roles.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{Action: data.AuditRoleRemove, ResourceType: "user", ResourceID: auditID(user.ID)}
+		return app.audit(r, tx, event, envelope{"roles": before}, envelope{"roles": roles})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.permissionCache.Invalidate(user.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/jwt"
+	"greenlight.chriss875.net/internal/testdb"
+)
+
+// newJWTTestApplication returns an application in JWT mode, signing with a fixed HS256 key.
+func newJWTTestApplication(t *testing.T, models data.Models) *application {
+	t.Helper()
+
+	key, err := jwt.NewHS256Key("test", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApplication(models)
+	app.config.tokens.accessTTL = 15 * time.Minute
+	app.config.tokens.refreshTTL = 24 * time.Hour
+
+	app.jwtKeys, err = jwt.NewKeySet("test", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app
+}
+
+// newJWTTestSession logs user in and returns the JWT access token and refresh token.
+func newJWTTestSession(t *testing.T, app *application, user *data.User) (*data.Token, *data.Token) {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", nil)
+	r = app.contextSetUser(r, data.AnonymousUser)
+
+	var access, refresh *data.Token
+
+	err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+		var err error
+		access, refresh, err = app.issueTokenPair(r, tx, user.ID, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return access, refresh
+}
+
+// serveAuthenticated runs a request with a bearer token through authenticate and handler.
+func serveAuthenticated(app *application, handler http.HandlerFunc, method, target, body, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	app.authenticate(handler).ServeHTTP(rr, r)
+
+	return rr
+}
+
+func TestJWTSessions(t *testing.T) {
+	ctx := context.Background()
+	models := data.NewModels(testdb.New(t), 5*time.Second)
+	app := newJWTTestApplication(t, models)
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access, refresh := newJWTTestSession(t, app, user)
+	_, otherRefresh := newJWTTestSession(t, app, user)
+
+	t.Run("current session", func(t *testing.T) {
+		rr := serveAuthenticated(app, app.listSessionsHandler, http.MethodGet, "/v1/users/me/sessions", "", access.Plaintext)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+		}
+
+		var response struct {
+			Sessions []*data.Session `json:"sessions"`
+		}
+
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		current := 0
+		for _, session := range response.Sessions {
+			if session.Current {
+				current++
+			}
+		}
+
+		if len(response.Sessions) != 2 || current != 1 {
+			t.Errorf("got %d sessions with %d current; want 2 with 1 current", len(response.Sessions), current)
+		}
+	})
+
+	t.Run("password change keeps the current session", func(t *testing.T) {
+		body := `{"current_password": "pa55word1234", "password": "n3w-pa55word1234"}`
+
+		rr := serveAuthenticated(app, app.changeCurrentUserPasswordHandler, http.MethodPut, "/v1/users/me/password", body, access.Plaintext)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+
+		_, err := models.Tokens.GetByHash(ctx, data.ScopeRefresh, refresh.Hash)
+		if err != nil {
+			t.Errorf("current session was revoked: %v", err)
+		}
+
+		_, err = models.Tokens.GetByHash(ctx, data.ScopeRefresh, otherRefresh.Hash)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("other session survived: %v", err)
+		}
+	})
+}
@@ -24,37 +24,523 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	v := validator.New()
 
 	data.ValidateEmail(v, input.Email)
-	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidatePasswordInput(v, input.Password)
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	emailKey := data.LoginFailureKeyForEmail(input.Email)
+	ipKey := data.LoginFailureKeyForIP(app.clientIP(r))
+
+	// Refuse outright while either the account or the client is locked out. Locks are
+	// tracked by email address, so this says nothing about whether the account exists.
+	lockedUntil, err := app.models.LoginFailures.LockedUntil(r.Context(), emailKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if lockedUntil != nil {
+		app.loginLockedResponse(w, r, *lockedUntil)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			// Spend as long as a real password check would, so that response timing
+			// doesn't reveal which email addresses have accounts.
+			data.SimulatePasswordCheck(input.Password)
+			app.failedLoginResponse(w, r, emailKey, ipKey, nil)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	match, err := user.Password.Matches(input.Password)
+	match, err := user.Password.Verify(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.failedLoginResponse(w, r, emailKey, ipKey, user)
+		return
+	}
+
+	// Now that we have the plaintext, upgrade a hash from an older algorithm or with
+	// outdated parameters. A failure here shouldn't stop the user logging in.
+	if user.Password.NeedsRehash() {
+		err = user.Password.Set(input.Password)
+		if err == nil {
+			err = app.models.Users.Update(r.Context(), user)
+		}
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	credential, err := app.models.TOTP.GetForUser(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// With two-factor authentication on, the password only earns a short-lived challenge
+	// token, to be exchanged along with a one-time password. Failed logins aren't cleared
+	// until that second step succeeds.
+	if credential != nil && credential.Confirmed {
+		var challengeToken *data.Token
+
+		err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+			var err error
+			challengeToken, err = app.issueToken(r, tx, user.ID, 5*time.Minute, data.ScopeTOTPChallenge)
+			return err
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{
+			"message":         "a one-time password is required to complete authentication",
+			"challenge_token": challengeToken,
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Failures from the client's IP address are left to expire, or logging in to an
+	// account of their own would let an attacker reset the count between guesses at
+	// other accounts.
+	err = app.models.LoginFailures.Clear(r.Context(), emailKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var accessToken, refreshToken *data.Token
+
+	// Start a new token family with a short-lived access token and a refresh token.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		accessToken, refreshToken, err = app.issueTokenPair(r, tx, user.ID, nil)
+		return err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"authentication_token": accessToken, "refresh_token": refreshToken}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// failedLoginResponse records a failed login against both the email address and the
+// client IP, emailing the user an unlock token when their account first gets locked, and
+// then sends an invalid credentials response. user is nil when no account exists.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, emailKey, ipKey string, user *data.User) {
+	failures, lockedUntil, err := app.models.LoginFailures.Record(r.Context(), emailKey, app.config.login.accountPolicy)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, _, err = app.models.LoginFailures.Record(r.Context(), ipKey, app.config.login.ipPolicy)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && lockedUntil != nil && failures == app.config.login.accountPolicy.Threshold {
+		var token *data.Token
+
+		err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+			var err error
+			token, err = app.issueToken(r, tx, user.ID, time.Hour, data.ScopeUnlock)
+			return err
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"unlockToken": token.Plaintext,
+			}
+			err := app.mailer.Send(user.Email, "token_account_unlock.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// issueToken creates a single-purpose token, such as an activation or password reset
+// token, and records the issue in the audit log. The actor is the authenticated user,
+// if there is one, so tokens requested anonymously have no actor.
+func (app *application) issueToken(r *http.Request, tx data.Models, userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	token, err := tx.Tokens.New(r.Context(), userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &data.AuditEvent{Action: data.AuditTokenIssue, ResourceType: "user", ResourceID: auditID(userID)}
+
+	err = app.audit(r, tx, event, nil, envelope{"scope": scope, "expiry": token.Expiry})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// issueTokenPair creates an access token and a refresh token in the given token family,
+// starting a new family if family is nil, and records the issue in the audit log. In JWT
+// mode the access token is a signed JWT and only the refresh token is stored.
+func (app *application) issueTokenPair(r *http.Request, tx data.Models, userID int64, family []byte) (*data.Token, *data.Token, error) {
+	var accessToken, refreshToken *data.Token
+	var err error
+
+	if app.jwtKeys == nil {
+		accessToken, refreshToken, err = tx.Tokens.NewPair(r.Context(), userID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, family, app.clientIP(r), r.UserAgent())
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		refreshToken, err = tx.Tokens.NewInFamily(r.Context(), userID, app.config.tokens.refreshTTL, data.ScopeRefresh, family, app.clientIP(r), r.UserAgent())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		accessToken, err = app.newJWTAccessToken(r.Context(), tx, userID, refreshToken.Family)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Tokens are issued to anonymous requests, so the user they are issued to is
+	// recorded as the actor.
+	event := &data.AuditEvent{ActorID: &userID, Action: data.AuditTokenIssue, ResourceType: "user", ResourceID: auditID(userID)}
+	after := envelope{
+		"scope":                data.ScopeAuthentication,
+		"access_token_expiry":  accessToken.Expiry,
+		"refresh_token_expiry": refreshToken.Expiry,
+		"user_agent":           r.UserAgent(),
+	}
+
+	err = app.audit(r, tx, event, nil, after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// refreshAuthenticationTokenHandler exchanges a refresh token for a new access and
+// refresh token pair. Each refresh token can only be used once: presenting one that has
+// already been rotated is treated as theft, and the whole token family is revoked.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refreshToken, err := app.models.Tokens.GetByHash(r.Context(), data.ScopeRefresh, data.TokenHash(input.TokenPlaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if refreshToken.RotatedAt != nil {
+		app.revokeTokenFamily(w, r, refreshToken.Family)
+		return
+	}
+
+	var newAccessToken, newRefreshToken *data.Token
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Tokens.MarkRotated(r.Context(), refreshToken.Hash)
+		if err != nil {
+			return err
+		}
+
+		// Retire the access tokens issued alongside the old refresh token.
+		err = tx.Tokens.DeleteAllForFamily(r.Context(), data.ScopeAuthentication, refreshToken.Family)
+		if err != nil {
+			return err
+		}
+
+		newAccessToken, newRefreshToken, err = app.issueTokenPair(r, tx, refreshToken.UserID, refreshToken.Family)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			// Another request rotated the same refresh token first.
+			app.revokeTokenFamily(w, r, refreshToken.Family)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"authentication_token": newAccessToken, "refresh_token": newRefreshToken}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeTokenFamily deletes every token descended from the same login as a reused
+// refresh token and sends an invalid refresh token response.
+func (app *application) revokeTokenFamily(w http.ResponseWriter, r *http.Request, family []byte) {
+	err := app.models.Tokens.DeleteFamily(r.Context(), family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("refresh token reuse detected, token family revoked", map[string]string{
+		"client_ip": app.clientIP(r),
+	})
+
+	app.invalidRefreshTokenResponse(w, r)
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	var token *data.Token
+
+	// Create a new password reset token with a 45-minute expiry time.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		var err error
+		token, err = app.issueToken(r, tx, user.ID, 45*time.Minute, data.ScopePasswordReset)
+		return err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var token *data.Token
+
+	// Replace any stale activation tokens with a fresh one.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err = app.issueToken(r, tx, user.ID, 3*24*time.Hour, data.ScopeActivation)
+		return err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"activationToken": token.Plaintext,
+		}
+		err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler revokes the authentication token that was presented
+// with the request, logging the client out. A JWT access token can't be revoked, so in
+// JWT mode the refresh tokens of its family are revoked instead, and the access token
+// stops working when it expires.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if family := app.contextGetTokenFamily(r); family != nil {
+		err := app.models.Tokens.DeleteFamily(r.Context(), family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{"message": "refresh tokens revoked, the access token remains valid until it expires"}
+
+		err = app.writeJSON(w, http.StatusOK, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tokenHash := app.contextGetTokenHash(r)
+	if tokenHash == nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	err := app.models.Tokens.DeleteByHash(r.Context(), data.ScopeAuthentication, tokenHash)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllAuthenticationTokensHandler revokes every authentication token belonging to
+// the current user, logging them out of all sessions.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeAuthentication, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeRefresh, user.ID)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens revoked"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
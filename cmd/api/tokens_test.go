@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/testdb"
+)
+
+// serveLogin posts a login request from the given client address.
+func serveLogin(app *application, remoteAddr, email, password string) *httptest.ResponseRecorder {
+	body := `{"email": "` + email + `", "password": "` + password + `"}`
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(body))
+	r.RemoteAddr = remoteAddr
+	r = app.contextSetUser(r, data.AnonymousUser)
+
+	rr := httptest.NewRecorder()
+	app.createAuthenticationTokenHandler(rr, r)
+
+	return rr
+}
+
+func TestLoginIPLockoutSurvivesSuccessfulLogin(t *testing.T) {
+	ctx := context.Background()
+	models := data.NewModels(testdb.New(t), 5*time.Second)
+	app := newTestApplication(models)
+
+	app.config.tokens.accessTTL = 15 * time.Minute
+	app.config.tokens.refreshTTL = 24 * time.Hour
+	app.config.login.accountPolicy = data.LockoutPolicy{Threshold: 100, Lockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+	app.config.login.ipPolicy = data.LockoutPolicy{Threshold: 3, Lockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+
+	user := &data.User{Name: "Mallory", Email: "mallory@example.com", Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const addr = "192.0.2.1:1234"
+
+	for _, guess := range []string{"guessa", "guessb"} {
+		rr := serveLogin(app, addr, "alice@example.com", guess)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: got status %d; want %d", guess, rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// Logging in to an account of one's own must not reset the count for the address.
+	rr := serveLogin(app, addr, user.Email, "pa55word1234")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("own login: got status %d; want %d", rr.Code, http.StatusCreated)
+	}
+
+	rr = serveLogin(app, addr, "alice@example.com", "guessc")
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("third guess: got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	rr = serveLogin(app, addr, "alice@example.com", "guessd")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("fourth guess: got status %d; want %d", rr.Code, http.StatusTooManyRequests)
+	}
+
+	// Another address is unaffected.
+	rr = serveLogin(app, "192.0.2.2:1234", user.Email, "pa55word1234")
+	if rr.Code != http.StatusCreated {
+		t.Errorf("other address: got status %d; want %d", rr.Code, http.StatusCreated)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/totp"
+	"greenlight.chriss875.net/internal/validator"
+)
+
+// totpIssuer is the name authenticator apps show next to Greenlight codes.
+const totpIssuer = "Greenlight"
+
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.Enroll(r.Context(), user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPAlreadyEnabled):
+			v := validator.New()
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	account := user.Email
+	if account == "" {
+		account = strconv.FormatInt(user.ID, 10)
+	}
+
+	env := envelope{"totp": map[string]string{
+		"secret": totp.EncodeSecret(secret),
+		"uri":    totp.URI(secret, totpIssuer, account),
+	}}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	credential, err := app.models.TOTP.GetForUser(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("totp", "two-factor authentication has not been set up")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if credential.Confirmed {
+		v.AddError("totp", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok := totp.Validate(credential.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "invalid one-time password")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var recoveryCodes []string
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.TOTP.UseStep(r.Context(), user.ID, step)
+		if err != nil {
+			return err
+		}
+
+		err = tx.TOTP.Confirm(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		recoveryCodes, err = tx.TOTP.NewRecoveryCodes(r.Context(), user.ID)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"message":        "two-factor authentication enabled, store these recovery codes somewhere safe",
+		"recovery_codes": recoveryCodes,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if validateSecondFactor(v, input.Code, input.RecoveryCode); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	credential, err := app.models.TOTP.GetForUser(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// An unconfirmed secret can simply be discarded; a confirmed one needs proof that
+	// the caller still holds the second factor.
+	if credential.Confirmed {
+		ok, err := app.checkSecondFactor(r.Context(), credential, input.Code, input.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			v.AddError("code", "invalid one-time password or recovery code")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		return tx.TOTP.Delete(r.Context(), user.ID)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTOTPAuthenticationTokenHandler completes a two-step login, exchanging the
+// challenge token from createAuthenticationTokenHandler and a one-time password or
+// recovery code for an authentication token.
+func (app *application) createTOTPAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	validateSecondFactor(v, input.Code, input.RecoveryCode)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeTOTPChallenge, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired challenge token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	emailKey := data.LoginFailureKeyForEmail(user.Email)
+	ipKey := data.LoginFailureKeyForIP(app.clientIP(r))
+
+	// Wrong codes count towards the same lockout as wrong passwords.
+	lockedUntil, err := app.models.LoginFailures.LockedUntil(r.Context(), emailKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if lockedUntil != nil {
+		app.loginLockedResponse(w, r, *lockedUntil)
+		return
+	}
+
+	credential, err := app.models.TOTP.GetForUser(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Two-factor authentication was turned off after the challenge was issued.
+	if credential == nil || !credential.Confirmed {
+		v.AddError("token", "invalid or expired challenge token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ok, err := app.checkSecondFactor(r.Context(), credential, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.failedLoginResponse(w, r, emailKey, ipKey, user)
+		return
+	}
+
+	var accessToken, refreshToken *data.Token
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeTOTPChallenge, user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.LoginFailures.Clear(r.Context(), emailKey)
+		if err != nil {
+			return err
+		}
+
+		accessToken, refreshToken, err = app.issueTokenPair(r, tx, user.ID, nil)
+		return err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"authentication_token": accessToken, "refresh_token": refreshToken}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateSecondFactor checks that exactly one of a one-time password and a recovery
+// code was provided.
+func validateSecondFactor(v *validator.Validator, code, recoveryCode string) {
+	if recoveryCode != "" {
+		v.Check(code == "", "code", "must not be provided together with a recovery code")
+		return
+	}
+
+	data.ValidateTOTPCode(v, code)
+}
+
+// checkSecondFactor verifies a one-time password, refusing a code that has already been
+// used, or else consumes a recovery code.
+func (app *application) checkSecondFactor(ctx context.Context, credential *data.TOTPCredential, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		err := app.models.TOTP.UseRecoveryCode(ctx, credential.UserID, recoveryCode)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		case err != nil:
+			return false, err
+		}
+		return true, nil
+	}
+
+	step, ok := totp.Validate(credential.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	err := app.models.TOTP.UseStep(ctx, credential.UserID, step)
+	switch {
+	case errors.Is(err, data.ErrEditConflict):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return true, nil
+}
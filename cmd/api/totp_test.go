@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.chriss875.net/internal/data"
+	"greenlight.chriss875.net/internal/testdb"
+	"greenlight.chriss875.net/internal/totp"
+)
+
+// serveTOTPChallenge logs in with a password and returns the challenge token issued to
+// an account with two-factor authentication turned on.
+func serveTOTPChallenge(t *testing.T, app *application, email, password string) string {
+	t.Helper()
+
+	rr := serveLogin(app, "192.0.2.1:1234", email, password)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("password login: got status %d; want %d", rr.Code, http.StatusAccepted)
+	}
+
+	var response struct {
+		ChallengeToken data.Token `json:"challenge_token"`
+	}
+
+	err := json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response.ChallengeToken.Plaintext
+}
+
+// serveTOTPLogin exchanges a challenge token and one-time password for an authentication token.
+func serveTOTPLogin(app *application, challenge, code string) *httptest.ResponseRecorder {
+	body := `{"token": "` + challenge + `", "code": "` + code + `"}`
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication/totp", strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:1234"
+	r = app.contextSetUser(r, data.AnonymousUser)
+
+	rr := httptest.NewRecorder()
+	app.createTOTPAuthenticationTokenHandler(rr, r)
+
+	return rr
+}
+
+func TestTOTPReplayedSteps(t *testing.T) {
+	ctx := context.Background()
+	models := data.NewModels(testdb.New(t), 5*time.Second)
+	app := newTestApplication(models)
+
+	app.config.tokens.accessTTL = 15 * time.Minute
+	app.config.tokens.refreshTTL = 24 * time.Hour
+	app.config.login.accountPolicy = data.LockoutPolicy{Threshold: 100, Lockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+	app.config.login.ipPolicy = data.LockoutPolicy{Threshold: 100, Lockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.TOTP.Enroll(ctx, user.ID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	code := totp.Code(secret, now)
+
+	r := httptest.NewRequest(http.MethodPut, "/v1/users/me/totp/confirmed", strings.NewReader(`{"code": "`+code+`"}`))
+	r = app.contextSetUser(r, user)
+
+	rr := httptest.NewRecorder()
+	app.confirmTOTPHandler(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("confirm: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	challenge := serveTOTPChallenge(t, app, user.Email, "pa55word1234")
+
+	// The code used to confirm enrollment can't then be used to log in.
+	rr = serveTOTPLogin(app, challenge, code)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("confirm code: got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	// Nor can a code from an earlier step, though it is within the skew window.
+	rr = serveTOTPLogin(app, challenge, totp.Code(secret, now.Add(-totp.Period*time.Second)))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("earlier code: got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	next := totp.Code(secret, now.Add(totp.Period*time.Second))
+
+	rr = serveTOTPLogin(app, challenge, next)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("next code: got status %d; want %d", rr.Code, http.StatusCreated)
+	}
+
+	// A code that logged in once can't log in again.
+	challenge = serveTOTPChallenge(t, app, user.Email, "pa55word1234")
+
+	rr = serveTOTPLogin(app, challenge, next)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed login code: got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
@@ -11,9 +11,10 @@ import (
 
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name            string `json:"name"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		InvitationToken string `json:"invitation_token"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -36,35 +37,94 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 
-	if data.ValidateUser(v, user); !v.Valid() {
+	data.ValidateUser(v, user)
+
+	if input.InvitationToken != "" {
+		v.Check(len(input.InvitationToken) == 26, "invitation_token", "must be 26 bytes long")
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	// Someone registering through an invitation has shown they own the email address by
+	// receiving it, so their account is activated straight away.
+	var invitation *data.Invitation
+
+	if input.InvitationToken != "" {
+		var ok bool
+		invitation, ok = app.invitationForEmail(w, r, v, "invitation_token", input.InvitationToken, user.Email)
+		if !ok {
+			return
+		}
+
+		user.Activated = true
+	}
+
+	var token *data.Token
+
+	// Insert the user, give them the default role and their own organization, and create
+	// the activation token in a single transaction so that a failure part way through
+	// leaves nothing behind.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Insert(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		// Give the user the default role.
+		err = tx.Roles.AddForUser(r.Context(), user.ID, app.config.roles.defaultRole)
+		if err != nil {
+			return err
+		}
+
+		// Give the user a personal organization, which they own, to keep their movies in.
+		organization := &data.Organization{Name: user.Name}
+
+		err = tx.Organizations.Insert(r.Context(), organization)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Organizations.AddMember(r.Context(), organization.ID, user.ID, data.OrgRoleOwner)
+		if err != nil {
+			return err
+		}
+
+		if invitation != nil {
+			err = tx.Organizations.AddMember(r.Context(), invitation.OrganizationID, user.ID, invitation.Role)
+			if err != nil {
+				return err
+			}
+
+			return tx.Invitations.Delete(r.Context(), invitation.OrganizationID, invitation.ID)
+		}
+
+		// Create a new activation token for the user.
+		token, err = app.issueToken(r, tx, user.ID, 3*24*time.Hour, data.ScopeActivation)
+		return err
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invitation_token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
-
 		}
 		return
 	}
 
-	// Add the "movies:read" permission to the user.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// Create a new activation token for the user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	// There is nothing to activate, so skip the welcome email and its activation token.
+	if invitation != nil {
+		err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -103,7 +163,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -116,10 +176,26 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Activate the user.
+	before := *user
 	user.Activated = true
 
-	// Update the user in the database.
-	err = app.models.Users.Update(user)
+	// Update the user, delete all of their activation tokens and record the activation
+	// atomically. The request is anonymous, so the user is recorded as activating
+	// themselves.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+		if err != nil {
+			return err
+		}
+
+		event := &data.AuditEvent{ActorID: &user.ID, Action: data.AuditUserActivate, ResourceType: "user", ResourceID: auditID(user.ID)}
+		return app.audit(r, tx, event, before, user)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -130,14 +206,132 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Delete all activation tokens for the user.
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Save the new password and revoke every outstanding token and API key for the user,
+	// so that existing sessions, machine access and any other reset tokens stop working
+	// straight away.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllScopesForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.APIKeys.DeleteAllForUser(r.Context(), user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"message": "your password was successfully reset"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unlockUserHandler lifts a login lockout early using the token emailed to the user when
+// their account was locked.
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeUnlock, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unlock token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.LoginFailures.Clear(r.Context(), data.LoginFailureKeyForEmail(user.Email))
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeUnlock, user.ID)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been unlocked"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,99 @@
+// Command breachfilter builds the bloom filter used by the API's offline breached
+// password check from a list of SHA-1 password hashes, such as the Have I Been Pwned
+// "ordered by hash" download:
+//
+//	go run ./cmd/breachfilter -in pwned-passwords-sha1.txt -out breached.bloom
+//
+// The API is then started with -breached-passwords=breached.bloom.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"greenlight.chriss875.net/internal/breach"
+)
+
+func main() {
+	in := flag.String("in", "", "File of hex SHA-1 hashes, one per line (required)")
+	out := flag.String("out", "", "Bloom filter file to write (required)")
+	fp := flag.Float64("fp", 0.001, "Target false positive rate")
+	flag.Parse()
+
+	if *in == "" || *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *fp <= 0 || *fp >= 1 {
+		log.Fatal("-fp must be between 0 and 1, exclusive")
+	}
+
+	// Count the hashes first so that the filter can be sized for them.
+	n, err := countLines(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	filter := breach.NewBloom(n, *fp)
+
+	f, err := os.Open(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	added, err := filter.AddHashes(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	o, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := bufio.NewWriter(o)
+
+	size, err := filter.WriteTo(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = o.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("wrote %d hashes to %s (%d bytes)", added, *out, size)
+}
+
+func countLines(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n uint64
+	buf := make([]byte, 64*1024)
+
+	for {
+		read, err := f.Read(buf)
+		for _, b := range buf[:read] {
+			if b == '\n' {
+				n++
+			}
+		}
+		if err == io.EOF {
+			return n + 1, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
@@ -4,11 +4,14 @@ go 1.25.1
 
 require github.com/julienschmidt/httprouter v1.3.0
 
-require github.com/lib/pq v1.10.9
+require (
+	github.com/go-mail/mail/v2 v2.3.0
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.43.0
+	golang.org/x/time v0.14.0
+)
 
 require (
-	github.com/go-mail/mail/v2 v2.3.0 // indirect
-	golang.org/x/crypto v0.43.0 // indirect
-	golang.org/x/time v0.14.0 // indirect
+	golang.org/x/sys v0.37.0 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 )
@@ -0,0 +1,156 @@
+package breach
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// bloomMagic identifies a bloom filter file, followed by a format version byte.
+const (
+	bloomMagic   = "GLBF"
+	bloomVersion = 1
+)
+
+var ErrInvalidBloomFile = errors.New("breach: invalid bloom filter file")
+
+// Bloom is a bloom filter over SHA-1 password hashes. It never reports a breached
+// password as safe, but reports a small fraction of safe passwords as breached.
+type Bloom struct {
+	k    uint8
+	m    uint64
+	bits []byte
+}
+
+// NewBloom returns an empty filter sized for n hashes with the given false positive rate.
+func NewBloom(n uint64, falsePositiveRate float64) *Bloom {
+	if n == 0 {
+		n = 1
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint8(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+
+	return &Bloom{k: k, m: m, bits: make([]byte, (m+7)/8)}
+}
+
+// positions derives the filter's k bit positions from the hash by double hashing. SHA-1
+// is already uniformly distributed, so its bytes are used directly.
+func (b *Bloom) positions(sum [sha1.Size]byte, fn func(pos uint64) bool) bool {
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+
+	for i := uint64(0); i < uint64(b.k); i++ {
+		if !fn((h1 + i*h2) % b.m) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddHash adds a SHA-1 hash to the filter.
+func (b *Bloom) AddHash(sum [sha1.Size]byte) {
+	b.positions(sum, func(pos uint64) bool {
+		b.bits[pos/8] |= 1 << (pos % 8)
+		return true
+	})
+}
+
+// Contains reports whether the password's hash is probably in the filter. The filter is
+// held in memory, so it never returns an error.
+func (b *Bloom) Contains(plaintext string) (bool, error) {
+	return b.positions(digest(plaintext), func(pos uint64) bool {
+		return b.bits[pos/8]&(1<<(pos%8)) != 0
+	}), nil
+}
+
+// AddHashes reads hex SHA-1 hashes, one per line, and adds them to the filter. Anything
+// after the first 40 characters of a line, such as an HIBP ":count" suffix, is ignored.
+// It returns the number of hashes added.
+func (b *Bloom) AddHashes(r io.Reader) (uint64, error) {
+	var added, lineNumber uint64
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if len(line) < 2*sha1.Size {
+			return added, fmt.Errorf("breach: line %d is not a SHA-1 hash", lineNumber)
+		}
+
+		var sum [sha1.Size]byte
+		_, err := hex.Decode(sum[:], []byte(line[:2*sha1.Size]))
+		if err != nil {
+			return added, fmt.Errorf("breach: line %d is not a SHA-1 hash: %w", lineNumber, err)
+		}
+
+		b.AddHash(sum)
+		added++
+	}
+
+	return added, scanner.Err()
+}
+
+// WriteTo writes the filter in the format read by LoadBloom.
+func (b *Bloom) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, len(bloomMagic)+1+1+8)
+	copy(header, bloomMagic)
+	header[len(bloomMagic)] = bloomVersion
+	header[len(bloomMagic)+1] = b.k
+	binary.BigEndian.PutUint64(header[len(bloomMagic)+2:], b.m)
+
+	n, err := w.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+
+	written, err := w.Write(b.bits)
+	return int64(n + written), err
+}
+
+// LoadBloom reads a bloom filter file into memory.
+func LoadBloom(path string) (*Bloom, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	header := make([]byte, len(bloomMagic)+1+1+8)
+	_, err = io.ReadFull(r, header)
+	if err != nil || string(header[:len(bloomMagic)]) != bloomMagic || header[len(bloomMagic)] != bloomVersion {
+		return nil, ErrInvalidBloomFile
+	}
+
+	b := &Bloom{
+		k: header[len(bloomMagic)+1],
+		m: binary.BigEndian.Uint64(header[len(bloomMagic)+2:]),
+	}
+
+	if b.k == 0 || b.m == 0 {
+		return nil, ErrInvalidBloomFile
+	}
+
+	b.bits = make([]byte, (b.m+7)/8)
+
+	_, err = io.ReadFull(r, b.bits)
+	if err != nil {
+		return nil, ErrInvalidBloomFile
+	}
+
+	return b, nil
+}
@@ -0,0 +1,177 @@
+package breach
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hashList returns the hex SHA-1 hashes of the passwords, one per line, with HIBP-style
+// counts.
+func hashList(passwords ...string) string {
+	var sb strings.Builder
+	for _, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		fmt.Fprintf(&sb, "%s:%d\n", strings.ToUpper(hex.EncodeToString(sum[:])), 42)
+	}
+	return sb.String()
+}
+
+// testPasswords returns n distinct passwords with the given prefix.
+func testPasswords(prefix string, n int) []string {
+	passwords := make([]string, n)
+	for i := range passwords {
+		passwords[i] = fmt.Sprintf("%s-%d", prefix, i)
+	}
+	return passwords
+}
+
+func TestBloomMembership(t *testing.T) {
+	breached := testPasswords("breached", 1000)
+
+	filter := NewBloom(uint64(len(breached)), 0.01)
+
+	added, err := filter.AddHashes(strings.NewReader(hashList(breached...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != uint64(len(breached)) {
+		t.Fatalf("added %d hashes; want %d", added, len(breached))
+	}
+
+	// A bloom filter never has false negatives.
+	for _, password := range breached {
+		if ok, _ := filter.Contains(password); !ok {
+			t.Errorf("%q isn't in the filter", password)
+		}
+	}
+}
+
+func TestBloomFalsePositiveRate(t *testing.T) {
+	tests := []struct {
+		n    int
+		rate float64
+	}{
+		{1000, 0.1},
+		{5000, 0.01},
+		{5000, 0.001},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("n=%d rate=%g", tt.n, tt.rate), func(t *testing.T) {
+			filter := NewBloom(uint64(tt.n), tt.rate)
+
+			_, err := filter.AddHashes(strings.NewReader(hashList(testPasswords("breached", tt.n)...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const trials = 100000
+
+			var positives int
+			for _, password := range testPasswords("safe", trials) {
+				if ok, _ := filter.Contains(password); ok {
+					positives++
+				}
+			}
+
+			// Allow twice the target rate, plus a little for small counts.
+			if got, limit := float64(positives)/trials, 2*tt.rate+0.0005; got > limit {
+				t.Errorf("false positive rate %g; want at most %g", got, limit)
+			}
+		})
+	}
+}
+
+func TestBloomAddHashesInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"too short", "ABCDEF\n"},
+		{"not hex", strings.Repeat("Z", 40) + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewBloom(10, 0.01).AddHashes(strings.NewReader(tt.input))
+			if err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestBloomFileRoundTrip(t *testing.T) {
+	breached := testPasswords("breached", 500)
+
+	filter := NewBloom(uint64(len(breached)), 0.01)
+
+	_, err := filter.AddHashes(strings.NewReader(hashList(breached...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = filter.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "breached.bloom")
+
+	err = os.WriteFile(path, buf.Bytes(), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checker, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, ok := checker.(*Bloom)
+	if !ok {
+		t.Fatalf("Open returned %T; want *Bloom", checker)
+	}
+
+	if loaded.k != filter.k || loaded.m != filter.m || !bytes.Equal(loaded.bits, filter.bits) {
+		t.Fatal("loaded filter differs from the one written")
+	}
+
+	for _, password := range append(breached, testPasswords("safe", 500)...) {
+		want, _ := filter.Contains(password)
+		if got, _ := loaded.Contains(password); got != want {
+			t.Errorf("%q: got %v; want %v", password, got, want)
+		}
+	}
+
+	t.Run("invalid files", func(t *testing.T) {
+		files := map[string][]byte{
+			"empty":     {},
+			"bad magic": append([]byte("XXXX"), buf.Bytes()[4:]...),
+			"truncated": buf.Bytes()[:buf.Len()-1],
+		}
+
+		for name, contents := range files {
+			path := filepath.Join(t.TempDir(), "invalid.bloom")
+
+			err := os.WriteFile(path, contents, 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = LoadBloom(path)
+			if !errors.Is(err, ErrInvalidBloomFile) {
+				t.Errorf("%s: got error %v; want %v", name, err, ErrInvalidBloomFile)
+			}
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package breach
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rangeFiles is the number of range files in a complete range directory, one for each 5
+// character hex prefix.
+const rangeFiles = 1 << 20
+
+// Checker reports whether a plaintext password appears in a corpus of breached
+// passwords. Checks are made entirely against local data. An error means the password
+// couldn't be checked, not that it is safe.
+type Checker interface {
+	Contains(plaintext string) (bool, error)
+}
+
+// Open returns a Checker for the given path. A directory is treated as a set of
+// HIBP-style range files, which must be complete, and anything else as a bloom filter
+// file built by cmd/breachfilter.
+func Open(path string) (Checker, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		n, err := countRangeFiles(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if n < rangeFiles {
+			return nil, fmt.Errorf("breach: %s has %d of the %d range files", path, n, rangeFiles)
+		}
+
+		return RangeDir(path), nil
+	}
+
+	return LoadBloom(path)
+}
+
+// countRangeFiles returns how many entries of a directory are named like range files.
+func countRangeFiles(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n int
+
+	for {
+		names, err := f.Readdirnames(4096)
+		for _, name := range names {
+			if isRangePrefix(strings.TrimSuffix(name, ".txt")) {
+				n++
+			}
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// isRangePrefix reports whether s is a 5 character hex prefix.
+func isRangePrefix(s string) bool {
+	if len(s) != 5 {
+		return false
+	}
+
+	_, err := hex.DecodeString(s + "0")
+	return err == nil
+}
+
+// digest returns the SHA-1 of a plaintext password, the form breached password corpora
+// are distributed in.
+func digest(plaintext string) [sha1.Size]byte {
+	return sha1.Sum([]byte(plaintext))
+}
+
+// RangeDir checks passwords against a directory of range files in the format served by
+// the Have I Been Pwned range API: one file per 5 character hex prefix of the SHA-1,
+// named "ABCDE" or "ABCDE.txt", each line holding the remaining 35 characters and a
+// count separated by a colon.
+type RangeDir string
+
+// Contains reports whether the password's hash is listed in its range file. A missing or
+// unreadable range file is an error, so that an incomplete directory can't pass breached
+// passwords as safe.
+func (d RangeDir) Contains(plaintext string) (bool, error) {
+	sum := digest(plaintext)
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(string(d), prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(string(d), prefix+".txt"))
+	}
+	if err != nil {
+		return false, fmt.Errorf("breach: range %s: %w", prefix, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+
+		// Padding entries added by the range API have a count of zero.
+		if strings.EqualFold(lineSuffix, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("breach: range %s: %w", prefix, err)
+	}
+
+	return false, nil
+}
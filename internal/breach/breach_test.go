@@ -0,0 +1,98 @@
+package breach
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRange writes a range file with the given name and lines to dir.
+func writeRange(t *testing.T, dir, name string, lines ...string) {
+	t.Helper()
+
+	err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\r\n")), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// splitHash returns the upper case range prefix and suffix of a password's SHA-1.
+func splitHash(password string) (string, string) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return hash[:5], hash[5:]
+}
+
+func TestRangeDirContains(t *testing.T) {
+	dir := t.TempDir()
+
+	prefix, suffix := splitHash("password")
+	writeRange(t, dir, prefix,
+		"0018A45C4D1DEF81644B54AB7F969B88D65:1",
+		suffix+":3861493",
+	)
+
+	// Lower case suffixes, and files named with a .txt extension.
+	txtPrefix, txtSuffix := splitHash("letmein")
+	writeRange(t, dir, txtPrefix+".txt", strings.ToLower(txtSuffix)+":42")
+
+	// The range API pads responses with entries that have a count of zero.
+	paddedPrefix, paddedSuffix := splitHash("padded")
+	writeRange(t, dir, paddedPrefix, paddedSuffix+":0")
+
+	// A range file that lists other hashes only.
+	unlistedPrefix, _ := splitHash("unlisted")
+	writeRange(t, dir, unlistedPrefix, "0018A45C4D1DEF81644B54AB7F969B88D65:1")
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{"listed", "password", true},
+		{"lower case in txt file", "letmein", true},
+		{"padding entry", "padded", false},
+		{"not listed in its range", "unlisted", false},
+	}
+
+	d := RangeDir(dir)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.Contains(tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing range file", func(t *testing.T) {
+		_, err := d.Contains("not-in-any-range-file")
+		if err == nil {
+			t.Error("got no error for a missing range file")
+		}
+	})
+}
+
+func TestOpenIncompleteRangeDir(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := Open(dir)
+	if err == nil {
+		t.Error("empty directory: got no error")
+	}
+
+	prefix, suffix := splitHash("password")
+	writeRange(t, dir, prefix, suffix+":1")
+
+	_, err = Open(dir)
+	if err == nil {
+		t.Error("directory with one range file: got no error")
+	}
+}
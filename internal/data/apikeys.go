@@ -0,0 +1,303 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"greenlight.chriss875.net/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// apiKeyPrefix marks a string as a Greenlight API key. A full key looks like
+// "gl_<prefix>_<secret>": the prefix identifies the key and is safe to display, while
+// only the hash of the secret is stored.
+const apiKeyPrefix = "gl_"
+
+var (
+	ErrDuplicateAPIKeyName = errors.New("duplicate api key name")
+)
+
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Prefix      string      `json:"prefix"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+}
+
+// IsScoped reports whether the key is restricted to a subset of its owner's permissions.
+func (k *APIKey) IsScoped() bool {
+	return len(k.Permissions) > 0
+}
+
+// generateAPIKey creates a new APIKey with a random prefix and secret. The plaintext key
+// is only ever available on the returned value.
+func generateAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	randomBytes := make([]byte, 5+16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	prefix := strings.ToLower(encoding.EncodeToString(randomBytes[:5]))
+	secret := encoding.EncodeToString(randomBytes[5:])
+
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Prefix:      prefix,
+		Plaintext:   apiKeyPrefix + prefix + "_" + secret,
+		Hash:        TokenHash(secret),
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+
+	return key, nil
+}
+
+// parseAPIKey splits a plaintext API key into its prefix and secret.
+func parseAPIKey(plaintext string) (prefix, secret string, ok bool) {
+	rest, found := strings.CutPrefix(plaintext, apiKeyPrefix)
+	if !found {
+		return "", "", false
+	}
+
+	prefix, secret, found = strings.Cut(rest, "_")
+	if !found || len(prefix) != 8 || len(secret) != 26 {
+		return "", "", false
+	}
+
+	return prefix, secret, true
+}
+
+// ValidateAPIKeyPlaintext checks that a plaintext API key is well formed.
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "api_key", "must be provided")
+
+	_, _, ok := parseAPIKey(plaintext)
+	v.Check(ok, "api_key", "must be a valid API key")
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+type APIKeyModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// New creates a new API key for a user
+func (m APIKeyModel) New(ctx context.Context, userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Insert(ctx, key)
+	return key, err
+}
+
+// Insert inserts an API key into the database
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	query := `
+        INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at`
+
+	permissions := key.Permissions
+	if permissions == nil {
+		permissions = Permissions{}
+	}
+
+	args := []interface{}{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(permissions), key.Expiry}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "api_keys_user_id_name_key"`:
+			return ErrDuplicateAPIKeyName
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForUser returns every API key belonging to a user, newest first
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+        SELECT id, user_id, name, prefix, permissions, created_at, expiry, last_used_at
+        FROM api_keys
+        WHERE user_id = $1
+        ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Permissions),
+			&key.CreatedAt,
+			&key.Expiry,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetForPlaintext returns the unexpired API key matching a plaintext key, together with
+// the user it belongs to
+func (m APIKeyModel) GetForPlaintext(ctx context.Context, plaintext string) (*APIKey, *User, error) {
+	prefix, secret, ok := parseAPIKey(plaintext)
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT api_keys.id, api_keys.user_id, api_keys.name, api_keys.prefix, api_keys.hash,
+               api_keys.permissions, api_keys.created_at, api_keys.expiry, api_keys.last_used_at,
+               users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+        FROM api_keys
+        INNER JOIN users ON users.id = api_keys.user_id
+        WHERE api_keys.prefix = $1
+        AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)
+        AND users.deleted_at IS NULL`
+
+	var key APIKey
+	var user User
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, prefix, time.Now()).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		pq.Array(&key.Permissions),
+		&key.CreatedAt,
+		&key.Expiry,
+		&key.LastUsedAt,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if subtle.ConstantTimeCompare(key.Hash, TokenHash(secret)) != 1 {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	return &key, &user, nil
+}
+
+// Touch records that an API key has just been used
+func (m APIKeyModel) Touch(ctx context.Context, id int64) error {
+	query := `
+        UPDATE api_keys
+        SET last_used_at = NOW()
+        WHERE id = $1`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// DeleteForUser deletes an API key by its ID, provided that it belongs to the given user
+func (m APIKeyModel) DeleteForUser(ctx context.Context, id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM api_keys
+        WHERE id = $1 AND user_id = $2`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser deletes every API key belonging to a user
+func (m APIKeyModel) DeleteAllForUser(ctx context.Context, userID int64) error {
+	query := `
+        DELETE FROM api_keys
+        WHERE user_id = $1`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,239 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditMovieCreate      = "movie.create"
+	AuditMovieUpdate      = "movie.update"
+	AuditMovieDelete      = "movie.delete"
+	AuditMovieRestore     = "movie.restore"
+	AuditUserActivate     = "user.activate"
+	AuditUserDeactivate   = "user.deactivate"
+	AuditPermissionCreate = "permission.create"
+	AuditPermissionGrant  = "permission.grant"
+	AuditPermissionRevoke = "permission.revoke"
+	AuditRoleCreate       = "role.create"
+	AuditRoleDelete       = "role.delete"
+	AuditRoleAssign       = "role.assign"
+	AuditRoleRemove       = "role.remove"
+	AuditRoleUpdate       = "role.update"
+	AuditTokenIssue       = "token.issue"
+	AuditAPIKeyCreate     = "api_key.create"
+	AuditAPIKeyDelete     = "api_key.delete"
+)
+
+// AuditEvent records a single change: who made it, to what, and how the resource looked
+// before and after.
+type AuditEvent struct {
+	ID           int64           `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ActorID      *int64          `json:"actor_id"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   string          `json:"resource_id"`
+	Changes      json.RawMessage `json:"changes"`
+	RequestID    string          `json:"request_id"`
+	ClientIP     string          `json:"client_ip"`
+}
+
+// AuditFilter narrows down the events returned by AuditModel.GetAll. Empty fields match
+// everything.
+type AuditFilter struct {
+	ActorID      *int64
+	Action       string
+	ResourceType string
+	ResourceID   string
+}
+
+// auditChange is the before and after value of a single field.
+type auditChange struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditChanges returns the fields that differ between the JSON encodings of before and
+// after, each with its old and new value. Either side may be nil, for a resource that is
+// being created or deleted.
+func AuditChanges(before, after interface{}) (json.RawMessage, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]auditChange)
+
+	for key, value := range beforeFields {
+		if !bytes.Equal(value, afterFields[key]) {
+			changes[key] = auditChange{Before: value, After: afterFields[key]}
+		}
+	}
+
+	for key, value := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			changes[key] = auditChange{After: value}
+		}
+	}
+
+	return json.Marshal(changes)
+}
+
+// auditFields splits the JSON encoding of v into its top-level fields.
+func auditFields(v interface{}) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+
+	err = json.Unmarshal(js, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+type AuditModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// Insert appends an event to the audit log. It should run in the same transaction as the
+// change it records.
+func (m AuditModel) Insert(ctx context.Context, event *AuditEvent) error {
+	query := `
+        INSERT INTO audit_events (actor_id, action, resource_type, resource_id, changes, request_id, client_ip)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, created_at`
+
+	args := []interface{}{event.ActorID, event.Action, event.ResourceType, event.ResourceID, []byte(event.Changes), event.RequestID, event.ClientIP}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// GetAll returns the events matching filter, a page at a time.
+func (m AuditModel) GetAll(ctx context.Context, filter AuditFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, actor_id, action, resource_type, resource_id, changes, request_id, client_ip
+        FROM audit_events
+        WHERE ($1::bigint IS NULL OR actor_id = $1)
+        AND ($2 = '' OR action = $2)
+        AND ($3 = '' OR resource_type = $3)
+        AND ($4 = '' OR resource_id = $4)
+        ORDER BY %s %s, id DESC
+        LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{filter.ActorID, filter.Action, filter.ResourceType, filter.ResourceID, filters.limit(), filters.offset()}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.Action,
+			&event.ResourceType,
+			&event.ResourceID,
+			&event.Changes,
+			&event.RequestID,
+			&event.ClientIP,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+// GetAllForActor returns every event a user caused, oldest first. It is used for
+// personal data exports.
+func (m AuditModel) GetAllForActor(ctx context.Context, userID int64) ([]*AuditEvent, error) {
+	query := `
+        SELECT id, created_at, actor_id, action, resource_type, resource_id, changes, request_id, client_ip
+        FROM audit_events
+        WHERE actor_id = $1
+        ORDER BY id`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.Action,
+			&event.ResourceType,
+			&event.ResourceID,
+			&event.Changes,
+			&event.RequestID,
+			&event.ClientIP,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
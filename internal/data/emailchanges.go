@@ -0,0 +1,64 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// EmailChangeModel stores the address a user has asked to move their account to while
+// it waits to be confirmed. Each user has at most one pending change.
+type EmailChangeModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// Set records newEmail as the user's pending address, replacing any earlier request.
+func (m EmailChangeModel) Set(ctx context.Context, userID int64, newEmail string) error {
+	query := `
+        INSERT INTO email_changes (user_id, new_email)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE
+        SET new_email = EXCLUDED.new_email, created_at = NOW()`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, newEmail)
+	return err
+}
+
+// GetForUser returns the user's pending address.
+func (m EmailChangeModel) GetForUser(ctx context.Context, userID int64) (string, error) {
+	query := `
+        SELECT new_email
+        FROM email_changes
+        WHERE user_id = $1`
+
+	var newEmail string
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&newEmail)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return newEmail, nil
+}
+
+// DeleteForUser discards the user's pending address, if any.
+func (m EmailChangeModel) DeleteForUser(ctx context.Context, userID int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM email_changes WHERE user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Invitation is a pending offer for whoever holds an email address to join an
+// organization with a given role.
+type Invitation struct {
+	ID               int64     `json:"id"`
+	OrganizationID   int64     `json:"organization_id"`
+	OrganizationName string    `json:"organization_name,omitempty"`
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	InvitedBy        *int64    `json:"invited_by"`
+	CreatedAt        time.Time `json:"created_at"`
+	Expiry           time.Time `json:"expiry"`
+	Plaintext        string    `json:"-"`
+}
+
+type InvitationModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// New creates an invitation with a fresh token. Inviting an address that already has a
+// pending invitation to the organization replaces it, so the old token stops working.
+func (m InvitationModel) New(ctx context.Context, organizationID int64, email, role string, invitedBy int64, ttl time.Duration) (*Invitation, error) {
+	// Invitations use the same token format as the tokens table, but aren't tied to a
+	// user since the invitee may not have registered yet.
+	token, err := generateToken(0, ttl, "")
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &Invitation{
+		OrganizationID: organizationID,
+		Email:          email,
+		Role:           role,
+		InvitedBy:      &invitedBy,
+		Expiry:         token.Expiry,
+		Plaintext:      token.Plaintext,
+	}
+
+	query := `
+        INSERT INTO organization_invitations (organization_id, email, role, invited_by, hash, expiry)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (organization_id, email) DO UPDATE
+        SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by, hash = EXCLUDED.hash,
+            expiry = EXCLUDED.expiry, created_at = NOW()
+        RETURNING id, created_at`
+
+	args := []interface{}{organizationID, email, role, invitedBy, token.Hash, token.Expiry}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// GetAllForOrganization returns an organization's unexpired invitations, newest first.
+func (m InvitationModel) GetAllForOrganization(ctx context.Context, organizationID int64) ([]*Invitation, error) {
+	query := `
+        SELECT id, organization_id, email, role, invited_by, created_at, expiry
+        FROM organization_invitations
+        WHERE organization_id = $1 AND expiry > NOW()
+        ORDER BY created_at DESC, id DESC`
+
+	return m.getAll(ctx, query, organizationID)
+}
+
+// GetAllSentBy returns every invitation a user has sent, including expired ones, oldest
+// first. It is used for personal data exports.
+func (m InvitationModel) GetAllSentBy(ctx context.Context, userID int64) ([]*Invitation, error) {
+	query := `
+        SELECT id, organization_id, email, role, invited_by, created_at, expiry
+        FROM organization_invitations
+        WHERE invited_by = $1
+        ORDER BY created_at, id`
+
+	return m.getAll(ctx, query, userID)
+}
+
+// GetAllForEmail returns every invitation sent to an email address, including expired
+// ones, oldest first. It is used for personal data exports.
+func (m InvitationModel) GetAllForEmail(ctx context.Context, email string) ([]*Invitation, error) {
+	query := `
+        SELECT id, organization_id, email, role, invited_by, created_at, expiry
+        FROM organization_invitations
+        WHERE email = $1
+        ORDER BY created_at, id`
+
+	return m.getAll(ctx, query, email)
+}
+
+// getAll runs a query returning invitations without their organization names.
+func (m InvitationModel) getAll(ctx context.Context, query string, arg interface{}) ([]*Invitation, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		var invitation Invitation
+
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.OrganizationID,
+			&invitation.Email,
+			&invitation.Role,
+			&invitation.InvitedBy,
+			&invitation.CreatedAt,
+			&invitation.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// GetForToken returns the unexpired invitation matching a plaintext token.
+func (m InvitationModel) GetForToken(ctx context.Context, tokenPlaintext string) (*Invitation, error) {
+	query := `
+        SELECT organization_invitations.id, organization_invitations.organization_id, organizations.name,
+               organization_invitations.email, organization_invitations.role, organization_invitations.invited_by,
+               organization_invitations.created_at, organization_invitations.expiry
+        FROM organization_invitations
+        INNER JOIN organizations ON organizations.id = organization_invitations.organization_id
+        WHERE organization_invitations.hash = $1 AND organization_invitations.expiry > NOW()`
+
+	var invitation Invitation
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, TokenHash(tokenPlaintext)).Scan(
+		&invitation.ID,
+		&invitation.OrganizationID,
+		&invitation.OrganizationName,
+		&invitation.Email,
+		&invitation.Role,
+		&invitation.InvitedBy,
+		&invitation.CreatedAt,
+		&invitation.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// Delete removes one of an organization's invitations, whether it is being revoked or
+// has been accepted. It returns ErrRecordNotFound if the organization has no such
+// invitation.
+func (m InvitationModel) Delete(ctx context.Context, organizationID, id int64) error {
+	query := `
+        DELETE FROM organization_invitations
+        WHERE organization_id = $1 AND id = $2`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, organizationID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
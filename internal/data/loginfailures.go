@@ -0,0 +1,135 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LockoutPolicy controls when repeated login failures lock an account or client out, and
+// for how long. Once Threshold failures have been recorded, the lockout starts at
+// Lockout and doubles with every further failure, up to MaxLockout. Failures older than
+// Window are forgotten.
+type LockoutPolicy struct {
+	Threshold  int
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	Window     time.Duration
+}
+
+// lockoutFor returns how long to lock out after the given number of failures, or zero if
+// the threshold hasn't been reached.
+func (p LockoutPolicy) lockoutFor(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+
+	lockout := p.Lockout
+	for i := p.Threshold; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+
+	if lockout > p.MaxLockout {
+		lockout = p.MaxLockout
+	}
+
+	return lockout
+}
+
+// LoginFailureKeyForEmail returns the key failed logins are tracked under for an email
+// address, whether or not an account exists for it.
+func LoginFailureKeyForEmail(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// LoginFailureKeyForIP returns the key failed logins are tracked under for a client IP
+// address.
+func LoginFailureKeyForIP(ip string) string {
+	return "ip:" + ip
+}
+
+type LoginFailureModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// LockedUntil returns the latest time any of the keys is locked out until, or nil if
+// none of them is currently locked
+func (m LoginFailureModel) LockedUntil(ctx context.Context, keys ...string) (*time.Time, error) {
+	query := `
+        SELECT max(locked_until)
+        FROM login_failures
+        WHERE key = ANY($1) AND locked_until > $2`
+
+	var lockedUntil *time.Time
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys), time.Now()).Scan(&lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	return lockedUntil, nil
+}
+
+// Record adds a failed login for a key and returns how many failures are now on record.
+// If that takes the key over the policy's threshold, the key is locked out and the
+// returned time says until when.
+func (m LoginFailureModel) Record(ctx context.Context, key string, policy LockoutPolicy) (int, *time.Time, error) {
+	query := `
+        INSERT INTO login_failures (key, failures, last_failure_at)
+        VALUES ($1, 1, $2)
+        ON CONFLICT (key) DO UPDATE
+        SET failures = CASE
+                WHEN login_failures.last_failure_at < $3 THEN 1
+                ELSE login_failures.failures + 1
+            END,
+            last_failure_at = EXCLUDED.last_failure_at
+        RETURNING failures`
+
+	now := time.Now()
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var failures int
+
+	err := m.DB.QueryRowContext(ctx, query, key, now, now.Add(-policy.Window)).Scan(&failures)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	lockout := policy.lockoutFor(failures)
+	if lockout == 0 {
+		return failures, nil, nil
+	}
+
+	lockedUntil := now.Add(lockout)
+
+	query = `
+        UPDATE login_failures
+        SET locked_until = $2
+        WHERE key = $1`
+
+	_, err = m.DB.ExecContext(ctx, query, key, lockedUntil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return failures, &lockedUntil, nil
+}
+
+// Clear forgets every failed login recorded for the keys, lifting any lockout
+func (m LoginFailureModel) Clear(ctx context.Context, keys ...string) error {
+	query := `
+        DELETE FROM login_failures
+        WHERE key = ANY($1)`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(keys))
+	return err
+}
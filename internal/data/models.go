@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -10,18 +12,103 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// DefaultQueryTimeout is the upper bound applied to a single database query when
+// no other timeout has been configured.
+const DefaultQueryTimeout = 3 * time.Second
+
+// DBTX is the set of methods shared by *sql.DB and *sql.Tx. The models only depend on
+// this interface, so the same code runs against the pool or inside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
+	APIKeys        APIKeyModel
+	Audit          AuditModel
+	EmailChanges   EmailChangeModel
+	Invitations    InvitationModel
+	LoginFailures  LoginFailureModel
+	Movies         MovieModel
+	MovieRevisions MovieRevisionModel
+	Organizations  OrganizationModel
+	Permissions    PermissionModel
+	Roles          RoleModel
+	TOTP           TOTPModel
+	Tokens         TokenModel
+	Users          UserModel
+
+	// db is nil when the Models value is already bound to a transaction.
+	db           *sql.DB
+	queryTimeout time.Duration
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns a Models value wired to the given connection pool. Every query is
+// run with the caller's context, bounded by queryTimeout.
+func NewModels(db *sql.DB, queryTimeout time.Duration) Models {
+	models := newModels(db, queryTimeout)
+	models.db = db
+	return models
+}
+
+func newModels(db DBTX, queryTimeout time.Duration) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		APIKeys:        APIKeyModel{DB: db, Timeout: queryTimeout},
+		Audit:          AuditModel{DB: db, Timeout: queryTimeout},
+		EmailChanges:   EmailChangeModel{DB: db, Timeout: queryTimeout},
+		Invitations:    InvitationModel{DB: db, Timeout: queryTimeout},
+		LoginFailures:  LoginFailureModel{DB: db, Timeout: queryTimeout},
+		Movies:         MovieModel{DB: db, Timeout: queryTimeout},
+		MovieRevisions: MovieRevisionModel{DB: db, Timeout: queryTimeout},
+		Organizations:  OrganizationModel{DB: db, Timeout: queryTimeout},
+		Permissions:    PermissionModel{DB: db, Timeout: queryTimeout},
+		Roles:          RoleModel{DB: db, Timeout: queryTimeout},
+		TOTP:           TOTPModel{DB: db, Timeout: queryTimeout},
+		Tokens:         TokenModel{DB: db, Timeout: queryTimeout},
+		Users:          UserModel{DB: db, Timeout: queryTimeout},
+		queryTimeout:   queryTimeout,
+	}
+}
+
+// WithTx runs fn inside a single database transaction. The Models value passed to fn
+// has every model bound to that transaction. The transaction is committed if fn
+// returns nil and rolled back if it returns an error or panics. Calling WithTx on a
+// Models value that is already bound to a transaction simply joins it.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.db == nil {
+		return fn(m)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(newModels(tx, m.queryTimeout))
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// withTimeout layers the per-query timeout on top of the caller's context, so that a
+// query is cancelled either when the request goes away or when the timeout expires,
+// whichever comes first.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
 	}
+	return context.WithTimeout(ctx, timeout)
 }
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"greenlight.chriss875.net/internal/testdb"
+)
+
+// tenantFixture is two organizations with one movie each.
+type tenantFixture struct {
+	models Models
+	orgA   *Organization
+	orgB   *Organization
+	movieA *Movie
+	movieB *Movie
+}
+
+func newTenantFixture(t *testing.T) *tenantFixture {
+	t.Helper()
+
+	ctx := context.Background()
+	f := &tenantFixture{models: NewModels(testdb.New(t), 5*time.Second)}
+
+	f.orgA = &Organization{Name: "A"}
+	f.orgB = &Organization{Name: "B"}
+
+	for _, org := range []*Organization{f.orgA, f.orgB} {
+		err := f.models.Organizations.Insert(ctx, org)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.movieA = &Movie{Title: "Movie A", Year: 2001, Runtime: 101, Genres: []string{"drama"}, OrganizationID: f.orgA.ID}
+	f.movieB = &Movie{Title: "Movie B", Year: 2002, Runtime: 102, Genres: []string{"comedy"}, OrganizationID: f.orgB.ID}
+
+	for _, movie := range []*Movie{f.movieA, f.movieB} {
+		err := f.models.Movies.Insert(ctx, movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = f.models.MovieRevisions.Insert(ctx, movie, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return f
+}
+
+func testFilters(sort string) Filters {
+	return Filters{Page: 1, PageSize: 20, Sort: sort, SortSafelist: []string{sort}}
+}
+
+func TestMovieModelTenantIsolation(t *testing.T) {
+	f := newTenantFixture(t)
+	ctx := context.Background()
+
+	t.Run("Get", func(t *testing.T) {
+		_, err := f.models.Movies.Get(ctx, f.orgA.ID, f.movieB.ID)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Fatalf("got error %v; want ErrRecordNotFound", err)
+		}
+
+		movie, err := f.models.Movies.Get(ctx, f.orgA.ID, f.movieA.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if movie.ID != f.movieA.ID {
+			t.Fatalf("got movie %d; want %d", movie.ID, f.movieA.ID)
+		}
+	})
+
+	t.Run("GetAll", func(t *testing.T) {
+		movies, metadata, err := f.models.Movies.GetAll(ctx, f.orgA.ID, "", []string{}, testFilters("id"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(movies) != 1 || movies[0].ID != f.movieA.ID || metadata.TotalRecords != 1 {
+			t.Fatalf("got %d movies (%d in total); want only movie %d", len(movies), metadata.TotalRecords, f.movieA.ID)
+		}
+
+		movies, _, err = f.models.Movies.GetAll(ctx, f.orgA.ID, "Movie B", []string{}, testFilters("id"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(movies) != 0 {
+			t.Fatalf("title search returned %d movies from another organization", len(movies))
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		movie := *f.movieB
+		movie.OrganizationID = f.orgA.ID
+		movie.Title = "Changed"
+
+		err := f.models.Movies.Update(ctx, &movie)
+		if !errors.Is(err, ErrEditConflict) {
+			t.Fatalf("got error %v; want ErrEditConflict", err)
+		}
+
+		stored, err := f.models.Movies.Get(ctx, f.orgB.ID, f.movieB.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Title != f.movieB.Title || stored.Version != f.movieB.Version {
+			t.Fatalf("movie was changed through another organization: %+v", stored)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		err := f.models.Movies.Delete(ctx, f.orgA.ID, f.movieB.ID)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Fatalf("got error %v; want ErrRecordNotFound", err)
+		}
+
+		_, err = f.models.Movies.Get(ctx, f.orgB.ID, f.movieB.ID)
+		if err != nil {
+			t.Fatalf("movie was deleted through another organization: %v", err)
+		}
+	})
+}
+
+func TestMovieRevisionModelTenantIsolation(t *testing.T) {
+	f := newTenantFixture(t)
+	ctx := context.Background()
+
+	t.Run("Get", func(t *testing.T) {
+		_, err := f.models.MovieRevisions.Get(ctx, f.orgA.ID, f.movieB.ID, f.movieB.Version)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Fatalf("got error %v; want ErrRecordNotFound", err)
+		}
+
+		_, err = f.models.MovieRevisions.Get(ctx, f.orgB.ID, f.movieB.ID, f.movieB.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("GetAll", func(t *testing.T) {
+		revisions, metadata, err := f.models.MovieRevisions.GetAll(ctx, f.orgA.ID, f.movieB.ID, testFilters("-version"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 0 || metadata.TotalRecords != 0 {
+			t.Fatalf("got %d revisions of another organization's movie; want none", len(revisions))
+		}
+
+		revisions, _, err = f.models.MovieRevisions.GetAll(ctx, f.orgB.ID, f.movieB.ID, testFilters("-version"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 1 {
+			t.Fatalf("got %d revisions; want 1", len(revisions))
+		}
+	})
+}
@@ -0,0 +1,329 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"greenlight.chriss875.net/internal/validator"
+)
+
+// Roles a member can hold within an organization, from most to least privileged.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleEditor = "editor"
+	OrgRoleViewer = "viewer"
+)
+
+var (
+	ErrDuplicateMember = errors.New("duplicate member")
+	ErrLastOwner       = errors.New("last owner")
+)
+
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+}
+
+// Membership is a user's role within an organization.
+type Membership struct {
+	OrganizationID   int64     `json:"organization_id"`
+	OrganizationName string    `json:"organization_name,omitempty"`
+	UserID           int64     `json:"user_id"`
+	UserName         string    `json:"user_name,omitempty"`
+	UserEmail        string    `json:"user_email,omitempty"`
+	Role             string    `json:"role"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// CanWriteMovies reports whether the member may add and change the organization's movies.
+func (m *Membership) CanWriteMovies() bool {
+	return m.Role != OrgRoleViewer
+}
+
+// CanManageMembers reports whether the member may add, remove and change the roles of
+// other members.
+func (m *Membership) CanManageMembers() bool {
+	return m.Role == OrgRoleOwner || m.Role == OrgRoleAdmin
+}
+
+func ValidateOrganization(v *validator.Validator, organization *Organization) {
+	v.Check(organization.Name != "", "name", "must be provided")
+	v.Check(len(organization.Name) <= 200, "name", "must not be more than 200 bytes long")
+}
+
+func ValidateOrgRole(v *validator.Validator, role string) {
+	v.Check(validator.In(role, OrgRoleOwner, OrgRoleAdmin, OrgRoleEditor, OrgRoleViewer), "role", "must be one of owner, admin, editor or viewer")
+}
+
+type OrganizationModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// Insert creates an organization.
+func (m OrganizationModel) Insert(ctx context.Context, organization *Organization) error {
+	query := `
+        INSERT INTO organizations (name)
+        VALUES ($1)
+        RETURNING id, created_at`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, organization.Name).Scan(&organization.ID, &organization.CreatedAt)
+}
+
+// AddMember adds a user to an organization with the given role. It returns
+// ErrDuplicateMember if they already belong to it.
+func (m OrganizationModel) AddMember(ctx context.Context, organizationID, userID int64, role string) error {
+	query := `
+        INSERT INTO organization_members (organization_id, user_id, role)
+        VALUES ($1, $2, $3)`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, organizationID, userID, role)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "organization_members_pkey"`:
+			return ErrDuplicateMember
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetMembership returns a user's membership of an organization. It returns
+// ErrRecordNotFound if they aren't a member, including when the organization doesn't
+// exist.
+func (m OrganizationModel) GetMembership(ctx context.Context, organizationID, userID int64) (*Membership, error) {
+	query := `
+        SELECT organization_members.organization_id, organizations.name, organization_members.user_id,
+               organization_members.role, organization_members.created_at
+        FROM organization_members
+        INNER JOIN organizations ON organizations.id = organization_members.organization_id
+        WHERE organization_members.organization_id = $1 AND organization_members.user_id = $2`
+
+	var membership Membership
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, organizationID, userID).Scan(
+		&membership.OrganizationID,
+		&membership.OrganizationName,
+		&membership.UserID,
+		&membership.Role,
+		&membership.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &membership, nil
+}
+
+// GetDefaultForUser returns the membership a user gets when they don't choose an
+// organization: the one they joined first.
+func (m OrganizationModel) GetDefaultForUser(ctx context.Context, userID int64) (*Membership, error) {
+	query := `
+        SELECT organization_members.organization_id, organizations.name, organization_members.user_id,
+               organization_members.role, organization_members.created_at
+        FROM organization_members
+        INNER JOIN organizations ON organizations.id = organization_members.organization_id
+        WHERE organization_members.user_id = $1
+        ORDER BY organization_members.created_at, organization_members.organization_id
+        LIMIT 1`
+
+	var membership Membership
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&membership.OrganizationID,
+		&membership.OrganizationName,
+		&membership.UserID,
+		&membership.Role,
+		&membership.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &membership, nil
+}
+
+// GetAllForUser returns every organization a user belongs to, in the order they joined.
+func (m OrganizationModel) GetAllForUser(ctx context.Context, userID int64) ([]*Membership, error) {
+	query := `
+        SELECT organization_members.organization_id, organizations.name, organization_members.user_id,
+               organization_members.role, organization_members.created_at
+        FROM organization_members
+        INNER JOIN organizations ON organizations.id = organization_members.organization_id
+        WHERE organization_members.user_id = $1
+        ORDER BY organization_members.created_at, organization_members.organization_id`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*Membership{}
+
+	for rows.Next() {
+		var membership Membership
+
+		err := rows.Scan(
+			&membership.OrganizationID,
+			&membership.OrganizationName,
+			&membership.UserID,
+			&membership.Role,
+			&membership.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		memberships = append(memberships, &membership)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+// GetMembers returns every member of an organization, in the order they joined.
+func (m OrganizationModel) GetMembers(ctx context.Context, organizationID int64) ([]*Membership, error) {
+	query := `
+        SELECT organization_members.organization_id, organization_members.user_id, users.name, users.email,
+               organization_members.role, organization_members.created_at
+        FROM organization_members
+        INNER JOIN users ON users.id = organization_members.user_id
+        WHERE organization_members.organization_id = $1 AND users.deleted_at IS NULL
+        ORDER BY organization_members.created_at, organization_members.user_id`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*Membership{}
+
+	for rows.Next() {
+		var member Membership
+
+		err := rows.Scan(
+			&member.OrganizationID,
+			&member.UserID,
+			&member.UserName,
+			&member.UserEmail,
+			&member.Role,
+			&member.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, &member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// UpdateMemberRole changes a member's role. It returns ErrRecordNotFound if the user
+// isn't a member, and ErrLastOwner if it would leave the organization without an owner.
+func (m OrganizationModel) UpdateMemberRole(ctx context.Context, organizationID, userID int64, role string) error {
+	query := `
+        UPDATE organization_members
+        SET role = $3
+        WHERE organization_id = $1 AND user_id = $2
+        AND ($3 = 'owner' OR role <> 'owner' OR EXISTS (
+            SELECT 1 FROM organization_members AS others
+            INNER JOIN users ON users.id = others.user_id
+            WHERE others.organization_id = $1 AND others.user_id <> $2 AND others.role = 'owner'
+            AND users.deleted_at IS NULL
+        ))`
+
+	return m.execMemberChange(ctx, query, organizationID, userID, role)
+}
+
+// RemoveMember takes a user out of an organization. It returns ErrRecordNotFound if the
+// user isn't a member, and ErrLastOwner if they are its only owner.
+func (m OrganizationModel) RemoveMember(ctx context.Context, organizationID, userID int64) error {
+	query := `
+        DELETE FROM organization_members
+        WHERE organization_id = $1 AND user_id = $2
+        AND (role <> 'owner' OR EXISTS (
+            SELECT 1 FROM organization_members AS others
+            INNER JOIN users ON users.id = others.user_id
+            WHERE others.organization_id = $1 AND others.user_id <> $2 AND others.role = 'owner'
+            AND users.deleted_at IS NULL
+        ))`
+
+	return m.execMemberChange(ctx, query, organizationID, userID)
+}
+
+// execMemberChange runs an update or delete of a single membership that refuses to remove
+// the last owner, and works out why when nothing changed.
+func (m OrganizationModel) execMemberChange(ctx context.Context, query string, organizationID, userID int64, extra ...interface{}) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	args := append([]interface{}{organizationID, userID}, extra...)
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	var exists bool
+
+	err = m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM organization_members WHERE organization_id = $1 AND user_id = $2)`, organizationID, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrLastOwner
+	}
+	return ErrRecordNotFound
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"greenlight.chriss875.net/internal/testdb"
+)
+
+func TestLastOwnerIgnoresDeletedUsers(t *testing.T) {
+	ctx := context.Background()
+	models := NewModels(testdb.New(t), 5*time.Second)
+
+	alice := insertTestUser(t, models, "alice@example.com")
+	bob := insertTestUser(t, models, "bob@example.com")
+	carol := insertTestUser(t, models, "carol@example.com")
+
+	org := &Organization{Name: "Shared"}
+
+	err := models.Organizations.Insert(ctx, org)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for user, role := range map[*User]string{alice: OrgRoleOwner, bob: OrgRoleOwner, carol: OrgRoleEditor} {
+		err := models.Organizations.AddMember(ctx, org.ID, user.ID, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bob is still an owner on paper until their account is purged, but can't act as one.
+	_, err = models.Users.SoftDelete(ctx, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Organizations.UpdateMemberRole(ctx, org.ID, alice.ID, OrgRoleAdmin)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("demoting alice: got %v; want %v", err, ErrLastOwner)
+	}
+
+	err = models.Organizations.RemoveMember(ctx, org.ID, alice.ID)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("removing alice: got %v; want %v", err, ErrLastOwner)
+	}
+
+	err = models.Organizations.UpdateMemberRole(ctx, org.ID, carol.ID, OrgRoleOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Organizations.RemoveMember(ctx, org.ID, alice.ID)
+	if err != nil {
+		t.Errorf("removing alice with carol as owner: got %v; want nil", err)
+	}
+}
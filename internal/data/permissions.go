@@ -2,36 +2,73 @@ package data
 
 import (
 	"context"
-	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
+
+	"greenlight.chriss875.net/internal/validator"
+)
+
+var (
+	ErrDuplicatePermission = errors.New("duplicate permission")
 )
 
+// PermissionCodeRX matches permission codes: colon separated segments such as
+// "movies:write", optionally ending in a "*" wildcard segment, or a lone "*".
+var PermissionCodeRX = regexp.MustCompile(`^(\*|[a-z0-9_-]+(:[a-z0-9_-]+)*(:\*)?)$`)
+
+type Permission struct {
+	ID   int64  `json:"id"`
+	Code string `json:"code"`
+}
+
 type Permissions []string
 
+// Include reports whether the permissions grant code. Besides exact matches, "*" grants
+// every code and a code ending in ":*" grants every code under that prefix, so
+// "movies:*" grants both "movies:read" and "movies:write".
 func (p Permissions) Include(code string) bool {
 	for i := range p {
-		if p[i] == code {
+		if p[i] == code || p[i] == "*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p[i], "*"); ok && strings.HasSuffix(prefix, ":") && strings.HasPrefix(code, prefix) {
 			return true
 		}
 	}
 	return false
 }
 
+func ValidatePermissionCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) <= 100, "code", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(code, PermissionCodeRX), "code", "must be lowercase colon-separated segments, optionally ending in *")
+}
+
 type PermissionModel struct {
-	DB *sql.DB
+	DB      DBTX
+	Timeout time.Duration
 }
 
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAllForUser returns the permission codes a user holds, both those granted to them
+// directly and those that come from their roles.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
         SELECT permissions.code
         FROM permissions
         INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-        INNER JOIN users ON users_permissions.user_id = users.id
-        WHERE users.id = $1`
+        WHERE users_permissions.user_id = $1
+        UNION
+        SELECT permissions.code
+        FROM permissions
+        INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+        INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+        WHERE users_roles.user_id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
@@ -57,12 +94,82 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
         INSERT INTO users_permissions
-        SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+        SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+        ON CONFLICT DO NOTHING`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// RemoveForUser revokes the given permission codes from a user. Codes the user doesn't
+// hold are ignored.
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+        DELETE FROM users_permissions
+        WHERE user_id = $1
+        AND permission_id IN (SELECT id FROM permissions WHERE code = ANY($2))`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// GetAll returns every permission code that can be granted, in code order.
+func (m PermissionModel) GetAll(ctx context.Context) ([]*Permission, error) {
+	query := `
+        SELECT id, code
+        FROM permissions
+        ORDER BY code`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []*Permission{}
+
+	for rows.Next() {
+		var permission Permission
+		err := rows.Scan(&permission.ID, &permission.Code)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, &permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// Insert creates a new permission code.
+func (m PermissionModel) Insert(ctx context.Context, permission *Permission) error {
+	query := `
+        INSERT INTO permissions (code)
+        VALUES ($1)
+        RETURNING id`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, permission.Code).Scan(&permission.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "permissions_code_key"`:
+			return ErrDuplicatePermission
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieRevision is a snapshot of a movie's content as it was at one version.
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy *int64    `json:"created_by"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year,omitempty"`
+	Runtime   Runtime   `json:"runtime,omitempty"`
+	Genres    []string  `json:"genres,omitempty"`
+}
+
+// MovieRevisionModel keeps the history of every movie. A revision is stored each time a
+// movie is created or updated, in the same transaction, and revisions are only ever
+// read through the organization that owns the movie.
+type MovieRevisionModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// Insert stores the movie's current content as the revision for its current version,
+// recording the user who made it.
+func (m MovieRevisionModel) Insert(ctx context.Context, movie *Movie, createdBy *int64) error {
+	query := `
+        INSERT INTO movie_revisions (movie_id, version, created_by, title, year, runtime, genres)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	args := []interface{}{movie.ID, movie.Version, createdBy, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Get returns one revision of a movie in the given organization.
+func (m MovieRevisionModel) Get(ctx context.Context, organizationID, movieID int64, version int32) (*MovieRevision, error) {
+	query := `
+        SELECT movie_revisions.movie_id, movie_revisions.version, movie_revisions.created_at, movie_revisions.created_by,
+               movie_revisions.title, movie_revisions.year, movie_revisions.runtime, movie_revisions.genres
+        FROM movie_revisions
+        INNER JOIN movies ON movies.id = movie_revisions.movie_id
+        WHERE movie_revisions.movie_id = $1 AND movie_revisions.version = $2 AND movies.organization_id = $3`
+
+	var revision MovieRevision
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version, organizationID).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.CreatedAt,
+		&revision.CreatedBy,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// GetAll returns a page of a movie's revisions in the given organization.
+func (m MovieRevisionModel) GetAll(ctx context.Context, organizationID, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), movie_revisions.movie_id, movie_revisions.version, movie_revisions.created_at,
+               movie_revisions.created_by, movie_revisions.title, movie_revisions.year, movie_revisions.runtime,
+               movie_revisions.genres
+        FROM movie_revisions
+        INNER JOIN movies ON movies.id = movie_revisions.movie_id
+        WHERE movie_revisions.movie_id = $1 AND movies.organization_id = $2
+        ORDER BY movie_revisions.%s %s
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, organizationID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.CreatedAt,
+			&revision.CreatedBy,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// GetAllForCreator returns every revision a user made, in whichever organization the
+// movie belongs to, oldest first. It is used for personal data exports.
+func (m MovieRevisionModel) GetAllForCreator(ctx context.Context, userID int64) ([]*MovieRevision, error) {
+	query := `
+        SELECT movie_id, version, created_at, created_by, title, year, runtime, genres
+        FROM movie_revisions
+        WHERE created_by = $1
+        ORDER BY created_at, movie_id, version`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&revision.MovieID,
+			&revision.Version,
+			&revision.CreatedAt,
+			&revision.CreatedBy,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+
+	"greenlight.chriss875.net/internal/validator"
+)
+
+var (
+	ErrDuplicateRole = errors.New("duplicate role")
+)
+
+var RoleNameRX = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Role is a named set of permissions. A user holds the permissions of every role they
+// have been given, as well as any granted to them directly.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(validator.Matches(role.Name, RoleNameRX), "name", "must only contain lowercase letters, digits, hyphens and underscores")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+}
+
+type RoleModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// GetAll returns every role with its permission codes, in name order.
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	query := `
+        SELECT roles.id, roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+        FROM roles
+        LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+        LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+        GROUP BY roles.id
+        ORDER BY roles.name`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(&role.ID, &role.Name, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Get returns a single role with its permission codes.
+func (m RoleModel) Get(ctx context.Context, id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT roles.id, roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+        FROM roles
+        LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+        LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+        WHERE roles.id = $1
+        GROUP BY roles.id`
+
+	var role Role
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&role.ID, &role.Name, pq.Array(&role.Permissions))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &role, nil
+}
+
+// Exists reports whether a role with the given name exists.
+func (m RoleModel) Exists(ctx context.Context, name string) (bool, error) {
+	var exists bool
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, name).Scan(&exists)
+	return exists, err
+}
+
+// Insert creates a role. Its permissions are set separately with SetPermissions.
+func (m RoleModel) Insert(ctx context.Context, role *Role) error {
+	query := `
+        INSERT INTO roles (name)
+        VALUES ($1)
+        RETURNING id`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, role.Name).Scan(&role.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRole
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetPermissions replaces a role's permissions with the given codes. Unknown codes are
+// ignored.
+func (m RoleModel) SetPermissions(ctx context.Context, roleID int64, codes ...string) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM roles_permissions WHERE role_id = $1`, roleID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO roles_permissions
+        SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = m.DB.ExecContext(ctx, query, roleID, pq.Array(codes))
+	return err
+}
+
+// Delete removes a role, taking it away from every user who had it.
+func (m RoleModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM roles WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAllForUser returns the names of a user's roles.
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+        SELECT roles.name
+        FROM roles
+        INNER JOIN users_roles ON users_roles.role_id = roles.id
+        WHERE users_roles.user_id = $1
+        ORDER BY roles.name`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+
+	for rows.Next() {
+		var role string
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// AddForUser gives a user the named roles. Roles the user already has, and unknown
+// names, are ignored.
+func (m RoleModel) AddForUser(ctx context.Context, userID int64, names ...string) error {
+	query := `
+        INSERT INTO users_roles
+        SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+        ON CONFLICT DO NOTHING`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
+
+// RemoveForUser takes the named roles away from a user.
+func (m RoleModel) RemoveForUser(ctx context.Context, userID int64, names ...string) error {
+	query := `
+        DELETE FROM users_roles
+        WHERE user_id = $1
+        AND role_id IN (SELECT id FROM roles WHERE name = ANY($2))`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
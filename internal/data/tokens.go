@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"greenlight.chriss875.net/internal/validator"
@@ -14,14 +15,51 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeUnlock         = "unlock"
+	ScopeTOTPChallenge  = "totp-challenge"
+	ScopeEmailChange    = "email-change"
 )
 
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	Plaintext string     `json:"token"`
+	Hash      []byte     `json:"-"`
+	UserID    int64      `json:"-"`
+	Expiry    time.Time  `json:"expiry"`
+	Scope     string     `json:"-"`
+	ClientIP  string     `json:"-"`
+	UserAgent string     `json:"-"`
+	Family    []byte     `json:"-"`
+	RotatedAt *time.Time `json:"-"`
+}
+
+// Session describes an authentication token as seen by its owner, without the token
+// itself. ClientIP and UserAgent are those of the client the token was issued to, and
+// the LastUsed fields those of the client that used it most recently.
+type Session struct {
+	ID                int64      `json:"id"`
+	CreatedAt         time.Time  `json:"created_at"`
+	LastUsedAt        *time.Time `json:"last_used_at"`
+	Expiry            time.Time  `json:"expiry"`
+	ClientIP          string     `json:"client_ip"`
+	UserAgent         string     `json:"user_agent"`
+	LastUsedIP        string     `json:"last_used_ip"`
+	LastUsedUserAgent string     `json:"last_used_user_agent"`
+	Current           bool       `json:"current"`
+}
+
+// TokenMetadata describes a stored token of any scope, without the token itself. It is
+// used for personal data exports.
+type TokenMetadata struct {
+	Scope             string     `json:"scope"`
+	CreatedAt         time.Time  `json:"created_at"`
+	LastUsedAt        *time.Time `json:"last_used_at"`
+	Expiry            time.Time  `json:"expiry"`
+	ClientIP          string     `json:"client_ip"`
+	UserAgent         string     `json:"user_agent"`
+	LastUsedIP        string     `json:"last_used_ip"`
+	LastUsedUserAgent string     `json:"last_used_user_agent"`
 }
 
 // generateToken creates a new Token with a unique plaintext, SHA256 hash, user ID, expiry time, and scope.
@@ -43,12 +81,31 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	token.Hash = TokenHash(token.Plaintext)
 
 	return token, nil
 }
 
+// generateTokenFamily returns a random identifier shared by every access and refresh
+// token descended from a single login.
+func generateTokenFamily() ([]byte, error) {
+	family := make([]byte, 16)
+
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, err
+	}
+
+	return family, nil
+}
+
+// TokenHash returns the SHA-256 hash of a plaintext token, which is the form tokens are
+// stored and looked up by in the database.
+func TokenHash(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
+
 // ValidateTokenPlaintext validates that the provided tokenPlaintext is not empty and is 26 bytes long.
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
@@ -56,38 +113,384 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB      DBTX
+	Timeout time.Duration
 }
 
 // New creates a new token
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	return m.NewForClient(ctx, userID, ttl, scope, "", "")
+}
+
+// NewForClient creates a new token, recording the IP address and user agent of the
+// client it is issued to
+func (m TokenModel) NewForClient(ctx context.Context, userID int64, ttl time.Duration, scope, clientIP, userAgent string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.ClientIP = clientIP
+	token.UserAgent = userAgent
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// NewPair creates a short-lived authentication token and a long-lived refresh token in
+// the given token family. A new family is started if family is nil. Both tokens should
+// be created inside a transaction.
+func (m TokenModel) NewPair(ctx context.Context, userID int64, accessTTL, refreshTTL time.Duration, family []byte, clientIP, userAgent string) (*Token, *Token, error) {
+	access, err := m.NewInFamily(ctx, userID, accessTTL, ScopeAuthentication, family, clientIP, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := m.NewInFamily(ctx, userID, refreshTTL, ScopeRefresh, access.Family, clientIP, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// NewInFamily creates a new token belonging to a token family, starting a new family if
+// family is nil
+func (m TokenModel) NewInFamily(ctx context.Context, userID int64, ttl time.Duration, scope string, family []byte, clientIP, userAgent string) (*Token, error) {
+	if family == nil {
+		var err error
+		family, err = generateTokenFamily()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
-	err = m.Insert(token)
+	token.ClientIP = clientIP
+	token.UserAgent = userAgent
+	token.Family = family
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
 // Insert inserts a token into the database
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
-        INSERT INTO tokens (hash, user_id, expiry, scope) 
-        VALUES ($1, $2, $3, $4)`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+        INSERT INTO tokens (hash, user_id, expiry, scope, client_ip, user_agent, family) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.ClientIP, token.UserAgent, token.Family}
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
 // DeleteAllForUser deletes all tokens for a user
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
         DELETE FROM tokens 
         WHERE scope = $1 AND user_id = $2`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllScopesForUser deletes every token belonging to a user, regardless of scope
+func (m TokenModel) DeleteAllScopesForUser(ctx context.Context, userID int64) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE user_id = $1`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteAllScopesForUserExceptFamily deletes every token belonging to a user apart from
+// those in the given family, so that the session making the request survives. A nil
+// family keeps nothing.
+func (m TokenModel) DeleteAllScopesForUserExceptFamily(ctx context.Context, userID int64, family []byte) error {
+	query := `
+        DELETE FROM tokens
+        WHERE user_id = $1 AND ($2::bytea IS NULL OR family IS DISTINCT FROM $2)`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, family)
+	return err
+}
+
+// DeleteByHash deletes a single token of the given scope by its hash, together with
+// every other token in the same family
+func (m TokenModel) DeleteByHash(ctx context.Context, scope string, hash []byte) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE (scope = $1 AND hash = $2)
+        OR family = (SELECT family FROM tokens WHERE scope = $1 AND hash = $2)`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, scope, hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Touch records that a token has just been used by the given client. The client it was
+// issued to is left as it was.
+func (m TokenModel) Touch(ctx context.Context, hash []byte, clientIP, userAgent string) error {
+	query := `
+        UPDATE tokens 
+        SET last_used_at = NOW(), last_used_ip = $2, last_used_user_agent = $3
+        WHERE hash = $1`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, hash, clientIP, userAgent)
+	return err
+}
+
+// GetSessionsForUser returns the sessions of a user, most recently used first. A session
+// is a token family with an unexpired refresh token that hasn't been rotated, so it
+// outlives its short-lived access tokens. Its ID, creation time and client are those of
+// the family's first refresh token, which is kept after rotation, so the ID doesn't
+// change when the session is refreshed. It was last used at the latest access token use
+// or refresh. The session in currentFamily, or containing the token matching currentHash,
+// is flagged as current.
+func (m TokenModel) GetSessionsForUser(ctx context.Context, userID int64, currentHash, currentFamily []byte) ([]*Session, error) {
+	query := `
+        SELECT first.id, first.created_at, used.used_at, refresh.expiry, first.client_ip, first.user_agent,
+            COALESCE(used.ip, ''), COALESCE(used.user_agent, ''),
+            COALESCE(refresh.family = $4 OR refresh.family = (SELECT family FROM tokens WHERE hash = $3), false)
+        FROM tokens refresh
+        CROSS JOIN LATERAL (
+            SELECT id, created_at, client_ip, user_agent
+            FROM tokens
+            WHERE family = refresh.family AND scope = $2
+            ORDER BY created_at, id
+            LIMIT 1
+        ) first
+        LEFT JOIN LATERAL (
+            SELECT used_at, ip, user_agent
+            FROM (
+                SELECT last_used_at AS used_at, last_used_ip AS ip, last_used_user_agent AS user_agent
+                FROM tokens
+                WHERE family = refresh.family AND last_used_at IS NOT NULL
+                UNION ALL
+                SELECT created_at, client_ip, user_agent
+                FROM tokens
+                WHERE family = refresh.family AND scope = $2 AND id <> first.id
+            ) uses
+            ORDER BY used_at DESC
+            LIMIT 1
+        ) used ON true
+        WHERE refresh.user_id = $1 AND refresh.scope = $2 AND refresh.rotated_at IS NULL AND refresh.expiry > NOW()
+        ORDER BY used.used_at DESC NULLS LAST, first.created_at DESC`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeRefresh, currentHash, currentFamily)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.ClientIP,
+			&session.UserAgent,
+			&session.LastUsedIP,
+			&session.LastUsedUserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// GetAllForUser returns the metadata of every token a user holds, expired or not, oldest
+// first.
+func (m TokenModel) GetAllForUser(ctx context.Context, userID int64) ([]*TokenMetadata, error) {
+	query := `
+        SELECT scope, created_at, last_used_at, expiry, client_ip, user_agent, last_used_ip, last_used_user_agent
+        FROM tokens
+        WHERE user_id = $1
+        ORDER BY created_at, id`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*TokenMetadata{}
+
+	for rows.Next() {
+		var token TokenMetadata
+
+		err := rows.Scan(
+			&token.Scope,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+			&token.Expiry,
+			&token.ClientIP,
+			&token.UserAgent,
+			&token.LastUsedIP,
+			&token.LastUsedUserAgent,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// DeleteSessionForUser deletes a session, provided that it belongs to the given user. id
+// may be that of any authentication or refresh token in the session's family, so IDs
+// listed before a refresh still work, and every token in the family is deleted.
+func (m TokenModel) DeleteSessionForUser(ctx context.Context, id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM tokens 
+        WHERE user_id = $2 
+        AND ((id = $1 AND scope IN ($3, $4)) 
+        OR family = (SELECT family FROM tokens WHERE id = $1 AND user_id = $2 AND scope IN ($3, $4)))`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeAuthentication, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetByHash returns an unexpired token of the given scope by its hash
+func (m TokenModel) GetByHash(ctx context.Context, scope string, hash []byte) (*Token, error) {
+	query := `
+        SELECT hash, user_id, expiry, scope, client_ip, user_agent, family, rotated_at
+        FROM tokens
+        WHERE hash = $1 AND scope = $2 AND expiry > $3`
+
+	var token Token
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash, scope, time.Now()).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.ClientIP,
+		&token.UserAgent,
+		&token.Family,
+		&token.RotatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// MarkRotated records that a refresh token has been exchanged for a new pair. It returns
+// ErrEditConflict if the token had already been rotated.
+func (m TokenModel) MarkRotated(ctx context.Context, hash []byte) error {
+	query := `
+        UPDATE tokens 
+        SET rotated_at = NOW()
+        WHERE hash = $1 AND rotated_at IS NULL`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// DeleteAllForFamily deletes all tokens of the given scope in a token family
+func (m TokenModel) DeleteAllForFamily(ctx context.Context, scope string, family []byte) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE scope = $1 AND family = $2`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, family)
+	return err
+}
+
+// DeleteFamily deletes every token in a token family, whatever its scope
+func (m TokenModel) DeleteFamily(ctx context.Context, family []byte) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE family = $1`
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, family)
+	return err
+}
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"greenlight.chriss875.net/internal/testdb"
+)
+
+// refreshTestSession rotates a session's refresh token the way the refresh endpoint does,
+// and returns the new pair.
+func refreshTestSession(t *testing.T, models Models, refresh *Token) (*Token, *Token) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	err := models.Tokens.MarkRotated(ctx, refresh.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Tokens.DeleteAllForFamily(ctx, ScopeAuthentication, refresh.Family)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access, newRefresh, err := models.Tokens.NewPair(ctx, refresh.UserID, 15*time.Minute, 24*time.Hour, refresh.Family, "192.0.2.2", "refresher")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return access, newRefresh
+}
+
+func TestSessionsOutliveAccessTokens(t *testing.T) {
+	ctx := context.Background()
+	models := NewModels(testdb.New(t), 5*time.Second)
+
+	user := insertTestUser(t, models, "alice@example.com")
+
+	// A session that has been refreshed since it was listed.
+	_, refreshed, err := models.Tokens.NewPair(ctx, user.ID, 15*time.Minute, 24*time.Hour, nil, "192.0.2.1", "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An idle session whose access token has already expired.
+	_, idle, err := models.Tokens.NewPair(ctx, user.ID, -time.Minute, 24*time.Hour, nil, "192.0.2.1", "idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := models.Tokens.GetSessionsForUser(ctx, user.ID, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions; want 2", len(sessions))
+	}
+
+	listed := make(map[string]int64)
+	for _, session := range sessions {
+		listed[session.UserAgent] = session.ID
+	}
+
+	access, current := refreshTestSession(t, models, refreshed)
+
+	sessions, err = models.Tokens.GetSessionsForUser(ctx, user.ID, access.Hash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("after refresh: got %d sessions; want 2", len(sessions))
+	}
+
+	for _, session := range sessions {
+		if session.UserAgent == "first" {
+			if session.ID != listed["first"] {
+				t.Errorf("refreshed session: got ID %d; want %d", session.ID, listed["first"])
+			}
+			if !session.Current {
+				t.Error("refreshed session isn't flagged as current")
+			}
+			if session.LastUsedUserAgent != "refresher" {
+				t.Errorf("refreshed session: got last used user agent %q; want %q", session.LastUsedUserAgent, "refresher")
+			}
+		}
+	}
+
+	for _, name := range []string{"first", "idle"} {
+		err := models.Tokens.DeleteSessionForUser(ctx, listed[name], user.ID)
+		if err != nil {
+			t.Fatalf("deleting %s session: %v", name, err)
+		}
+	}
+
+	for _, token := range []*Token{current, idle} {
+		_, err := models.Tokens.GetByHash(ctx, ScopeRefresh, token.Hash)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("refresh token survived its session being deleted: %v", err)
+		}
+	}
+
+	sessions, err = models.Tokens.GetSessionsForUser(ctx, user.ID, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("got %d sessions after deleting them all; want 0", len(sessions))
+	}
+}
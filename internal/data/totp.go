@@ -0,0 +1,246 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"greenlight.chriss875.net/internal/validator"
+)
+
+// recoveryCodeCount is the number of single-use recovery codes issued when two-factor
+// authentication is confirmed.
+const recoveryCodeCount = 10
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+)
+
+type TOTPCredential struct {
+	UserID       int64
+	CreatedAt    time.Time
+	Secret       []byte
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+// ValidateTOTPCode checks that a code looks like a six digit one-time password.
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+// generateRecoveryCode returns a random code in the form "xxxxx-xxxxx".
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 7)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+
+	return code[:5] + "-" + code[5:10], nil
+}
+
+// normalizeRecoveryCode makes recovery code matching forgiving of case and whitespace.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+type TOTPModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// GetForUser returns a user's TOTP credential, confirmed or not
+func (m TOTPModel) GetForUser(ctx context.Context, userID int64) (*TOTPCredential, error) {
+	query := `
+        SELECT user_id, created_at, secret, confirmed, last_used_step
+        FROM totp_credentials
+        WHERE user_id = $1`
+
+	var credential TOTPCredential
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&credential.UserID,
+		&credential.CreatedAt,
+		&credential.Secret,
+		&credential.Confirmed,
+		&credential.LastUsedStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &credential, nil
+}
+
+// Enroll stores a new, unconfirmed secret for a user, replacing any earlier unconfirmed
+// one. It returns ErrTOTPAlreadyEnabled if the user has already confirmed a secret.
+func (m TOTPModel) Enroll(ctx context.Context, userID int64, secret []byte) error {
+	query := `
+        INSERT INTO totp_credentials (user_id, secret)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE
+        SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+        WHERE totp_credentials.confirmed = false`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+	return nil
+}
+
+// Confirm marks a user's secret as confirmed, turning on two-factor authentication
+func (m TOTPModel) Confirm(ctx context.Context, userID int64) error {
+	query := `
+        UPDATE totp_credentials
+        SET confirmed = true
+        WHERE user_id = $1 AND confirmed = false`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// UseStep records that the code for a time step has been used. It returns
+// ErrEditConflict if that step, or a later one, has already been used, so a code can't
+// be replayed.
+func (m TOTPModel) UseStep(ctx context.Context, userID, step int64) error {
+	query := `
+        UPDATE totp_credentials
+        SET last_used_step = $2
+        WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Delete turns off two-factor authentication for a user and discards their recovery codes
+func (m TOTPModel) Delete(ctx context.Context, userID int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM totp_credentials WHERE user_id = $1`, userID)
+	return err
+}
+
+// NewRecoveryCodes replaces a user's recovery codes with a fresh set and returns them in
+// plaintext. Only their hashes are stored.
+func (m TOTPModel) NewRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = TokenHash(code)
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range hashes {
+		_, err = m.DB.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode consumes one of a user's recovery codes. It returns ErrRecordNotFound
+// if the code doesn't match an unused one.
+func (m TOTPModel) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `
+        DELETE FROM recovery_codes
+        WHERE user_id = $1 AND hash = $2`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, TokenHash(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -2,12 +2,15 @@ package data
 
 import (
 	"context"
-	"crypto/sha256"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"greenlight.chriss875.net/internal/breach"
+	"greenlight.chriss875.net/internal/passwords"
 	"greenlight.chriss875.net/internal/validator"
 )
 
@@ -33,7 +36,8 @@ var (
 )
 
 type UserModel struct {
-	DB *sql.DB
+	DB      DBTX
+	Timeout time.Duration
 }
 
 // IsAnonymous Check if the user is anonymous
@@ -41,9 +45,18 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// PasswordHashers hashes new passwords with its default algorithm and verifies existing
+// hashes with whichever algorithm produced them. It is replaced at startup to apply the
+// configured algorithm and parameters.
+var PasswordHashers = passwords.NewSet(passwords.DefaultArgon2id(), passwords.Bcrypt{Cost: 12})
+
+// BreachedPasswords, when set, is consulted for every new password. Passwords it
+// contains are rejected. It is nil unless a breached password corpus is configured.
+var BreachedPasswords breach.Checker
+
 // Set updates the plaintext and hashed representations of the password using the provided plaintext password.
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := PasswordHashers.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -56,16 +69,45 @@ func (p *password) Set(plaintextPassword string) error {
 
 // Matches returns true if the provided plaintext password matches the stored hash.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	return PasswordHashers.Matches(plaintextPassword, p.hash)
+}
+
+// Verify is like Matches, but for logins: it takes as long whichever algorithm produced
+// the stored hash, and as long as SimulatePasswordCheck.
+func (p *password) Verify(plaintextPassword string) (bool, error) {
+	return PasswordHashers.Verify(plaintextPassword, p.hash)
+}
+
+// NeedsRehash returns true if the stored hash was produced by an older algorithm or with
+// outdated parameters, and should be replaced the next time the plaintext is known.
+func (p *password) NeedsRehash() bool {
+	return PasswordHashers.NeedsRehash(p.hash)
+}
+
+// SimulatePasswordCheck compares the plaintext against throwaway hashes, so that a login
+// attempt for an unknown email address takes as long as one with a wrong password,
+// whichever algorithm that account's hash uses.
+func SimulatePasswordCheck(plaintextPassword string) {
+	PasswordHashers.Verify(plaintextPassword, nil)
+}
+
+// Scramble replaces the password with a random one that nobody knows, so that the user has
+// to reset it before they can log in again.
+func (p *password) Scramble() error {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		return err
 	}
-	return true, nil
+
+	err = p.Set(base64.RawStdEncoding.EncodeToString(randomBytes))
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = nil
+	return nil
 }
 
 // Validations Checks
@@ -74,10 +116,33 @@ func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
 }
+
+// ValidatePasswordPlaintext checks a new password being chosen by a user.
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= 1024, "password", "must not be more than 1024 bytes long")
+
+	// Only the password's own errors matter here, so that a mistake in another field
+	// doesn't hide that the password is breached.
+	if _, ok := v.Errors["password"]; !ok && BreachedPasswords != nil {
+		// A password that can't be checked is refused rather than assumed to be safe.
+		breached, err := BreachedPasswords.Contains(password)
+		if err != nil {
+			v.AddError("password", "could not be checked against known data breaches, please try again later")
+			return
+		}
+
+		v.Check(!breached, "password", "has appeared in a known data breach, please choose a different password")
+	}
+}
+
+// ValidatePasswordInput checks a password supplied to authenticate. Unlike
+// ValidatePasswordPlaintext it doesn't apply the rules for choosing a password, so that
+// existing passwords which no longer meet them can still be used to log in.
+func ValidatePasswordInput(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) <= 1024, "password", "must not be more than 1024 bytes long")
 }
 
 // ValidateUser applies validation checks on a User object's fields and adds error messages to the Validator instance.
@@ -98,7 +163,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 // Database interaction methods
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 		 INSERT INTO users (name, email, password_hash, activated) 
 		 VALUES ($1, $2, $3, $4)
@@ -106,7 +171,7 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -122,15 +187,52 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
 	query := `
         SELECT id, created_at, name, email, password_hash, activated, version
         FROM users
-        WHERE email = $1`
+        WHERE id = $1 AND deleted_at IS NULL`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+        SELECT id, created_at, name, email, password_hash, activated, version
+        FROM users
+        WHERE email = $1 AND deleted_at IS NULL`
+
+	var user User
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -156,11 +258,11 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
         UPDATE users 
         SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-        WHERE id = $5 AND version = $6
+        WHERE id = $5 AND version = $6 AND deleted_at IS NULL
         RETURNING version`
 
 	args := []interface{}{
@@ -172,7 +274,7 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -190,8 +292,8 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
-	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := TokenHash(tokenPlaintext)
 
 	query := `
         SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
@@ -200,12 +302,13 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
         ON users.id = tokens.user_id
         WHERE tokens.hash = $1
         AND tokens.scope = $2 
-        AND tokens.expiry > $3`
+        AND tokens.expiry > $3
+        AND users.deleted_at IS NULL`
 
-	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
+	args := []interface{}{tokenHash, tokenScope, time.Now()}
 
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -228,3 +331,161 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	return &user, nil
 }
+
+// GetAll returns a page of users whose name or email contains search, optionally limited
+// to activated or unactivated users. Deleted users are left out.
+func (m UserModel) GetAll(ctx context.Context, search string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, name, email, activated, version
+        FROM users
+        WHERE deleted_at IS NULL
+        AND ($1 = '' OR strpos(lower(name), lower($1)) > 0 OR strpos(lower(email::text), lower($1)) > 0)
+        AND ($2::bool IS NULL OR activated = $2)
+        ORDER BY %s %s, id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, search, activated, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// SoftDelete marks a user as deleted and returns when. A deleted user can no longer be
+// found by the other UserModel methods, and is removed for good by PurgeDeleted once the
+// grace period is over. It returns ErrEditConflict if the user has changed since it was
+// read.
+func (m UserModel) SoftDelete(ctx context.Context, user *User) (time.Time, error) {
+	query := `
+        UPDATE users
+        SET deleted_at = NOW(), version = version + 1
+        WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+        RETURNING deleted_at, version`
+
+	var deletedAt time.Time
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.ID, user.Version).Scan(&deletedAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrEditConflict
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return deletedAt, nil
+}
+
+// PurgeDeleted permanently removes users that were soft deleted before the given time,
+// along with their login failure records. Everything else stored about them goes with
+// them through ON DELETE CASCADE. Organizations nobody else belongs to are removed with
+// their movies, and an organization that would be left without an owner has its most
+// senior remaining member made owner. It returns the number of users removed, and should
+// be run inside a transaction.
+func (m UserModel) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	// A member "remains" if they aren't being purged now.
+	query := `
+        DELETE FROM organizations
+        WHERE id IN (
+            SELECT organization_members.organization_id
+            FROM organization_members
+            INNER JOIN users ON users.id = organization_members.user_id
+            WHERE users.deleted_at < $1
+        )
+        AND NOT EXISTS (
+            SELECT 1
+            FROM organization_members
+            INNER JOIN users ON users.id = organization_members.user_id
+            WHERE organization_members.organization_id = organizations.id
+            AND (users.deleted_at IS NULL OR users.deleted_at >= $1)
+        )`
+
+	_, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+        UPDATE organization_members
+        SET role = 'owner'
+        WHERE (organization_id, user_id) IN (
+            SELECT DISTINCT ON (members.organization_id) members.organization_id, members.user_id
+            FROM organization_members AS members
+            INNER JOIN users ON users.id = members.user_id
+            WHERE (users.deleted_at IS NULL OR users.deleted_at >= $1)
+            AND NOT EXISTS (
+                SELECT 1
+                FROM organization_members AS owners
+                INNER JOIN users AS owner_users ON owner_users.id = owners.user_id
+                WHERE owners.organization_id = members.organization_id AND owners.role = 'owner'
+                AND (owner_users.deleted_at IS NULL OR owner_users.deleted_at >= $1)
+            )
+            ORDER BY members.organization_id,
+                     CASE members.role WHEN 'admin' THEN 0 WHEN 'editor' THEN 1 ELSE 2 END,
+                     members.created_at, members.user_id
+        )`
+
+	_, err = m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+        DELETE FROM login_failures
+        WHERE key IN (
+            SELECT 'email:' || lower(email::text) FROM users WHERE deleted_at < $1
+        )`
+
+	_, err = m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM users WHERE deleted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
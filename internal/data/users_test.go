@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"greenlight.chriss875.net/internal/testdb"
+	"greenlight.chriss875.net/internal/validator"
+)
+
+func insertTestUser(t *testing.T, models Models, email string) *User {
+	t.Helper()
+
+	user := &User{Name: email, Email: email, Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestPurgeDeletedOrganizationOwnership(t *testing.T) {
+	ctx := context.Background()
+	models := NewModels(testdb.New(t), 5*time.Second)
+
+	alice := insertTestUser(t, models, "alice@example.com")
+	bob := insertTestUser(t, models, "bob@example.com")
+
+	personal := &Organization{Name: "Alice"}
+	shared := &Organization{Name: "Shared"}
+
+	for _, org := range []*Organization{personal, shared} {
+		err := models.Organizations.Insert(ctx, org)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = models.Organizations.AddMember(ctx, org.ID, alice.ID, OrgRoleOwner)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := models.Organizations.AddMember(ctx, shared.ID, bob.ID, OrgRoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movie := &Movie{Title: "Personal", Year: 2001, Runtime: 90, Genres: []string{"drama"}, OrganizationID: personal.ID}
+
+	err = models.Movies.Insert(ctx, movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.Users.SoftDelete(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.WithTx(ctx, func(tx Models) error {
+		_, err := tx.Users.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.Movies.Get(ctx, personal.ID, movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("movie in the purged user's personal organization: got error %v; want ErrRecordNotFound", err)
+	}
+
+	membership, err := models.Organizations.GetMembership(ctx, shared.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if membership.Role != OrgRoleOwner {
+		t.Errorf("remaining member of the shared organization has role %q; want %q", membership.Role, OrgRoleOwner)
+	}
+}
+
+type breachedList []string
+
+func (b breachedList) Contains(plaintext string) (bool, error) {
+	return validator.In(plaintext, b...), nil
+}
+
+func TestValidatePasswordPlaintextBreached(t *testing.T) {
+	saved := BreachedPasswords
+	BreachedPasswords = breachedList{"password123"}
+	t.Cleanup(func() { BreachedPasswords = saved })
+
+	tests := []struct {
+		name     string
+		password string
+		other    bool
+		want     bool
+	}{
+		{"breached", "password123", false, true},
+		{"breached with another field invalid", "password123", true, true},
+		{"not breached", "correct-horse-battery", true, false},
+		{"too short", "pass", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			if tt.other {
+				v.AddError("email", "must be provided")
+			}
+
+			ValidatePasswordPlaintext(v, tt.password)
+
+			if _, got := v.Errors["password"]; got != tt.want {
+				t.Errorf("password error = %v, want %v (errors: %v)", got, tt.want, v.Errors)
+			}
+		})
+	}
+}
+
+type failingChecker struct{}
+
+func (failingChecker) Contains(plaintext string) (bool, error) {
+	return false, errors.New("range file missing")
+}
+
+func TestValidatePasswordPlaintextCheckFails(t *testing.T) {
+	saved := BreachedPasswords
+	BreachedPasswords = failingChecker{}
+	t.Cleanup(func() { BreachedPasswords = saved })
+
+	v := validator.New()
+	ValidatePasswordPlaintext(v, "correct-horse-battery")
+
+	if _, ok := v.Errors["password"]; !ok {
+		t.Error("a password that couldn't be checked was accepted")
+	}
+}
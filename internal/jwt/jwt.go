@@ -0,0 +1,238 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("jwt: invalid token")
+	ErrExpiredToken = errors.New("jwt: token has expired")
+	ErrUnknownKey   = errors.New("jwt: unknown key id")
+)
+
+// b64 is the unpadded base64url encoding used for every part of a JWT.
+var b64 = base64.RawURLEncoding
+
+// Claims holds the payload of the access tokens issued by the API.
+type Claims struct {
+	Subject     string   `json:"sub"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+	// Organization is the organization requests act in unless they choose another.
+	Organization int64 `json:"org,omitempty"`
+	// Family identifies the login the token descends from, so that logging out can
+	// revoke the refresh tokens issued alongside it.
+	Family string `json:"fam,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Key is a single signing key, identified by its key ID so that tokens signed with an
+// older key can still be verified after the signing key has been rotated.
+type Key struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+}
+
+// NewHS256Key returns an HMAC-SHA256 key. The secret must be at least 32 bytes long.
+func NewHS256Key(id string, secret []byte) (*Key, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("jwt: HS256 key %q must be at least 32 bytes long", id)
+	}
+
+	return &Key{ID: id, Algorithm: AlgHS256, secret: secret}, nil
+}
+
+// NewEdDSAKey returns an Ed25519 key derived from a 32-byte seed.
+func NewEdDSAKey(id string, seed []byte) (*Key, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("jwt: EdDSA key %q must be a %d-byte seed", id, ed25519.SeedSize)
+	}
+
+	private := ed25519.NewKeyFromSeed(seed)
+
+	return &Key{ID: id, Algorithm: AlgEdDSA, private: private, public: private.Public().(ed25519.PublicKey)}, nil
+}
+
+func (k *Key) sign(signingInput []byte) []byte {
+	switch k.Algorithm {
+	case AlgEdDSA:
+		return ed25519.Sign(k.private, signingInput)
+	default:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil)
+	}
+}
+
+func (k *Key) verify(signingInput, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgEdDSA:
+		return ed25519.Verify(k.public, signingInput, signature)
+	default:
+		return hmac.Equal(k.sign(signingInput), signature)
+	}
+}
+
+// KeySet holds every key that tokens may be verified with, and the one new tokens are
+// signed with.
+type KeySet struct {
+	keys    map[string]*Key
+	signing *Key
+}
+
+// NewKeySet returns a KeySet which signs with the key identified by signingKeyID.
+func NewKeySet(signingKeyID string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key)}
+
+	for _, key := range keys {
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	signing, ok := ks.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("jwt: signing key %q is not in the key set", signingKeyID)
+	}
+	ks.signing = signing
+
+	return ks, nil
+}
+
+// ParseKeySet builds a KeySet from a comma-separated list of keys in the form
+// "kid:alg:base64key", where alg is HS256 or EdDSA. HS256 keys are the raw secret and
+// EdDSA keys are the 32-byte private key seed, both in standard base64.
+func ParseKeySet(spec, signingKeyID string) (*KeySet, error) {
+	var keys []*Key
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("jwt: invalid key %q, expected kid:alg:base64key", entry)
+		}
+
+		material, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("jwt: invalid base64 for key %q: %w", parts[0], err)
+		}
+
+		var key *Key
+
+		switch parts[1] {
+		case AlgHS256:
+			key, err = NewHS256Key(parts[0], material)
+		case AlgEdDSA:
+			key, err = NewEdDSAKey(parts[0], material)
+		default:
+			err = fmt.Errorf("jwt: unsupported algorithm %q for key %q", parts[1], parts[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(signingKeyID, keys...)
+}
+
+// Sign encodes and signs the claims with the current signing key.
+func (ks *KeySet) Sign(claims Claims) (string, error) {
+	h, err := json.Marshal(header{Algorithm: ks.signing.Algorithm, Type: "JWT", KeyID: ks.signing.ID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := b64.EncodeToString(h) + "." + b64.EncodeToString(payload)
+	signature := ks.signing.sign([]byte(signingInput))
+
+	return signingInput + "." + b64.EncodeToString(signature), nil
+}
+
+// Verify checks the token's signature against the key named in its header and returns
+// its claims, provided the token has not expired at the given time.
+func (ks *KeySet) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	rawHeader, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := json.Unmarshal(rawHeader, &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := ks.keys[h.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	// The algorithm is fixed by the key, never chosen by the token.
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
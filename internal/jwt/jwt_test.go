@@ -0,0 +1,225 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	hs256Secret = bytes.Repeat([]byte("s"), 32)
+	eddsaSeed   = bytes.Repeat([]byte("e"), 32)
+)
+
+func newTestKeySet(t *testing.T, signingKeyID string) *KeySet {
+	t.Helper()
+
+	hs256, err := NewHS256Key("hs", hs256Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eddsa, err := NewEdDSAKey("ed", eddsaSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := NewKeySet(signingKeyID, hs256, eddsa)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ks
+}
+
+func testClaims(now time.Time) Claims {
+	return Claims{
+		Subject:      "42",
+		IssuedAt:     now.Unix(),
+		ExpiresAt:    now.Add(15 * time.Minute).Unix(),
+		Activated:    true,
+		Permissions:  []string{"movies:read", "movies:write"},
+		Organization: 7,
+		Family:       "ZmFtaWx5",
+	}
+}
+
+// forge builds a token from a raw header and claims with the given signature.
+func forge(t *testing.T, h header, claims Claims, signature []byte) string {
+	t.Helper()
+
+	rawHeader, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b64.EncodeToString(rawHeader) + "." + b64.EncodeToString(payload) + "." + b64.EncodeToString(signature)
+}
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Now()
+
+	for _, kid := range []string{"hs", "ed"} {
+		t.Run(kid, func(t *testing.T) {
+			ks := newTestKeySet(t, kid)
+			claims := testClaims(now)
+
+			token, err := ks.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ks.Verify(token, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(*got, claims) {
+				t.Errorf("got claims %+v; want %+v", *got, claims)
+			}
+		})
+	}
+}
+
+// A token signed with a key that has since stopped being the signing key still verifies.
+func TestVerifyAfterRotation(t *testing.T) {
+	now := time.Now()
+
+	token, err := newTestKeySet(t, "hs").Sign(testClaims(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = newTestKeySet(t, "ed").Verify(token, now)
+	if err != nil {
+		t.Errorf("got error %v; want none", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Now()
+	ks := newTestKeySet(t, "hs")
+	claims := testClaims(now)
+
+	valid, err := ks.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, ".")
+
+	hs256, _ := NewHS256Key("hs", hs256Secret)
+	eddsa, _ := NewEdDSAKey("ed", eddsaSeed)
+
+	// An HS256 token whose secret is the EdDSA public key, the classic algorithm
+	// confusion attack.
+	confused := &Key{ID: "ed", Algorithm: AlgHS256, secret: eddsa.public}
+	confusedHeader := header{Algorithm: AlgHS256, Type: "JWT", KeyID: "ed"}
+	confusedInput := strings.Join(strings.Split(forge(t, confusedHeader, claims, nil), ".")[:2], ".")
+
+	expired := claims
+	expired.ExpiresAt = now.Add(-time.Second).Unix()
+	expiredHeader := header{Algorithm: AlgHS256, Type: "JWT", KeyID: "hs"}
+	expiredToken := forge(t, expiredHeader, expired, nil)
+	expiredInput := strings.Join(strings.Split(expiredToken, ".")[:2], ".")
+
+	tampered := claims
+	tampered.Permissions = []string{"*"}
+	tamperedPayload, _ := json.Marshal(tampered)
+
+	signature, _ := b64.DecodeString(parts[2])
+	signature[0] ^= 0xff
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"not a JWT", "abc", ErrInvalidToken},
+		{"alg none", forge(t, header{Algorithm: "none", Type: "JWT", KeyID: "hs"}, claims, nil), ErrInvalidToken},
+		{"alg none without signature", strings.TrimSuffix(forge(t, header{Algorithm: "none", Type: "JWT", KeyID: "hs"}, claims, nil), "."), ErrInvalidToken},
+		{"alg mismatch", forge(t, header{Algorithm: AlgEdDSA, Type: "JWT", KeyID: "hs"}, claims, hs256.sign([]byte(parts[0]+"."+parts[1]))), ErrInvalidToken},
+		{"algorithm confusion", confusedInput + "." + b64.EncodeToString(confused.sign([]byte(confusedInput))), ErrInvalidToken},
+		{"unknown kid", forge(t, header{Algorithm: AlgHS256, Type: "JWT", KeyID: "gone"}, claims, nil), ErrUnknownKey},
+		{"expired", expiredInput + "." + b64.EncodeToString(hs256.sign([]byte(expiredInput))), ErrExpiredToken},
+		{"tampered payload", parts[0] + "." + b64.EncodeToString(tamperedPayload) + "." + parts[2], ErrInvalidToken},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + b64.EncodeToString(signature), ErrInvalidToken},
+		{"missing signature", parts[0] + "." + parts[1] + ".", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ks.Verify(tt.token, now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+			if claims != nil {
+				t.Errorf("got claims %+v; want none", *claims)
+			}
+		})
+	}
+
+	t.Run("expires at exp", func(t *testing.T) {
+		_, err := ks.Verify(valid, time.Unix(claims.ExpiresAt, 0))
+		if !errors.Is(err, ErrExpiredToken) {
+			t.Errorf("got error %v; want %v", err, ErrExpiredToken)
+		}
+	})
+
+	t.Run("EdDSA tampered signature", func(t *testing.T) {
+		eks := newTestKeySet(t, "ed")
+
+		token, err := eks.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parts := strings.Split(token, ".")
+		signature, _ := b64.DecodeString(parts[2])
+		signature[len(signature)-1] ^= 0x01
+
+		_, err = eks.Verify(parts[0]+"."+parts[1]+"."+b64.EncodeToString(signature), now)
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("got error %v; want %v", err, ErrInvalidToken)
+		}
+	})
+}
+
+func TestParseKeySet(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(hs256Secret)
+	seed := base64.StdEncoding.EncodeToString(eddsaSeed)
+
+	tests := []struct {
+		name    string
+		spec    string
+		signing string
+		wantErr bool
+	}{
+		{"both algorithms", "hs:HS256:" + secret + ", ed:EdDSA:" + seed, "ed", false},
+		{"short HS256 secret", "hs:HS256:" + base64.StdEncoding.EncodeToString([]byte("short")), "hs", true},
+		{"wrong EdDSA seed size", "ed:EdDSA:" + secret + "AA==", "ed", true},
+		{"unsupported algorithm", "rs:RS256:" + secret, "rs", true},
+		{"none algorithm", "n:none:" + secret, "n", true},
+		{"duplicate kid", "hs:HS256:" + secret + ",hs:HS256:" + secret, "hs", true},
+		{"missing signing key", "hs:HS256:" + secret, "other", true},
+		{"malformed entry", "hs:" + secret, "hs", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseKeySet(tt.spec, tt.signing)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v; want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
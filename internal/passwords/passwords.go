@@ -0,0 +1,273 @@
+package passwords
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("passwords: hash was produced by an unknown algorithm")
+	ErrMalformedHash    = errors.New("passwords: malformed hash")
+)
+
+// Hasher is a single password hashing algorithm. Hashes are self-describing: each
+// encodes the algorithm and parameters that produced it, so hashes from older
+// algorithms or parameters can still be verified and recognised as outdated.
+type Hasher interface {
+	// Hash returns the encoded hash of a plaintext password.
+	Hash(plaintext string) ([]byte, error)
+	// Recognizes reports whether an encoded hash was produced by this algorithm.
+	Recognizes(encoded []byte) bool
+	// Matches reports whether the plaintext password matches an encoded hash.
+	Matches(plaintext string, encoded []byte) (bool, error)
+	// Outdated reports whether an encoded hash uses different parameters from the ones
+	// this hasher would use today.
+	Outdated(encoded []byte) bool
+}
+
+// Set hashes new passwords with its default hasher, and verifies existing hashes with
+// whichever of its hashers recognises them.
+type Set struct {
+	hashers []Hasher
+	dummies *dummyHashes
+}
+
+// dummyHashes holds a throwaway hash from each hasher in a Set, made the first time
+// Verify needs them.
+type dummyHashes struct {
+	once   sync.Once
+	hashes [][]byte
+}
+
+// NewSet returns a Set that hashes with def and can also verify hashes from others.
+func NewSet(def Hasher, others ...Hasher) Set {
+	return Set{hashers: append([]Hasher{def}, others...), dummies: &dummyHashes{}}
+}
+
+// Hash returns the encoded hash of a plaintext password using the default hasher.
+func (s Set) Hash(plaintext string) ([]byte, error) {
+	return s.hashers[0].Hash(plaintext)
+}
+
+// Matches reports whether the plaintext password matches an encoded hash.
+func (s Set) Matches(plaintext string, encoded []byte) (bool, error) {
+	for _, h := range s.hashers {
+		if h.Recognizes(encoded) {
+			return h.Matches(plaintext, encoded)
+		}
+	}
+	return false, ErrUnknownAlgorithm
+}
+
+// Verify is like Matches, but takes as long whichever hasher produced the encoded hash,
+// and whether or not there is one. Every hasher in the set checks the plaintext, against
+// a throwaway hash of its own if encoded isn't one of its, so timing reveals neither
+// whether an account exists nor how old its hash is. A nil encoded hash never matches.
+func (s Set) Verify(plaintext string, encoded []byte) (bool, error) {
+	s.dummies.once.Do(func() {
+		s.dummies.hashes = make([][]byte, len(s.hashers))
+		for i, h := range s.hashers {
+			s.dummies.hashes[i], _ = h.Hash("greenlight-dummy-password")
+		}
+	})
+
+	recognized := -1
+	for i, h := range s.hashers {
+		if encoded != nil && h.Recognizes(encoded) {
+			recognized = i
+			break
+		}
+	}
+
+	var match bool
+	var err error
+
+	for i, h := range s.hashers {
+		if i == recognized {
+			match, err = h.Matches(plaintext, encoded)
+			continue
+		}
+		h.Matches(plaintext, s.dummies.hashes[i])
+	}
+
+	if encoded != nil && recognized < 0 {
+		return false, ErrUnknownAlgorithm
+	}
+
+	return match, err
+}
+
+// NeedsRehash reports whether an encoded hash should be replaced, either because it was
+// produced by a hasher other than the default or with outdated parameters.
+func (s Set) NeedsRehash(encoded []byte) bool {
+	def := s.hashers[0]
+	return !def.Recognizes(encoded) || def.Outdated(encoded)
+}
+
+// Argon2id hashes passwords with argon2id, encoded in the PHC string format
+// "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>".
+type Argon2id struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id returns the recommended argon2id parameters.
+func DefaultArgon2id() Argon2id {
+	return Argon2id{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+var argon2idPrefix = []byte("$argon2id$")
+
+func (a Argon2id) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, a.SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(encoded), nil
+}
+
+func (a Argon2id) Recognizes(encoded []byte) bool {
+	return bytes.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a Argon2id) Matches(plaintext string, encoded []byte) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (a Argon2id) Outdated(encoded []byte) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != a.Memory ||
+		params.Iterations != a.Iterations ||
+		params.Parallelism != a.Parallelism ||
+		uint32(len(salt)) != a.SaltLength ||
+		uint32(len(key)) != a.KeyLength
+}
+
+func decodeArgon2id(encoded []byte) (Argon2id, []byte, []byte, error) {
+	// The leading "$" produces an empty first field.
+	fields := strings.Split(string(encoded), "$")
+	if len(fields) != 6 || fields[1] != "argon2id" {
+		return Argon2id{}, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(fields[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2id{}, nil, nil, ErrMalformedHash
+	}
+
+	var params Argon2id
+	_, err = fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2id{}, nil, nil, ErrMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return Argon2id{}, nil, nil, ErrMalformedHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(key) == 0 {
+		return Argon2id{}, nil, nil, ErrMalformedHash
+	}
+
+	return params, salt, key, nil
+}
+
+// Bcrypt hashes passwords with bcrypt. Its hashes are already in the self-describing
+// "$2a$<cost>$..." format. bcrypt can't take more than 72 bytes, so longer passwords are
+// pre-hashed to fit.
+type Bcrypt struct {
+	Cost int
+}
+
+// The range of bcrypt costs Bcrypt accepts.
+const (
+	BcryptMinCost = bcrypt.MinCost
+	BcryptMaxCost = bcrypt.MaxCost
+)
+
+// bcryptMaxLength is the longest password bcrypt can hash.
+const bcryptMaxLength = 72
+
+// bcryptInput returns the bytes bcrypt is given for a password. Passwords longer than
+// bcrypt accepts are replaced by their base64-encoded SHA-256 digest, so that every byte
+// of them still counts.
+func bcryptInput(plaintext string) []byte {
+	if len(plaintext) <= bcryptMaxLength {
+		return []byte(plaintext)
+	}
+
+	sum := sha256.Sum256([]byte(plaintext))
+	return []byte(base64.RawStdEncoding.EncodeToString(sum[:]))
+}
+
+func (b Bcrypt) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword(bcryptInput(plaintext), b.Cost)
+}
+
+func (b Bcrypt) Recognizes(encoded []byte) bool {
+	return bytes.HasPrefix(encoded, []byte("$2a$")) ||
+		bytes.HasPrefix(encoded, []byte("$2b$")) ||
+		bytes.HasPrefix(encoded, []byte("$2y$"))
+}
+
+func (b Bcrypt) Matches(plaintext string, encoded []byte) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(encoded, bcryptInput(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (b Bcrypt) Outdated(encoded []byte) bool {
+	cost, err := bcrypt.Cost(encoded)
+	return err != nil || cost != b.Cost
+}
@@ -0,0 +1,88 @@
+package passwords
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBcryptLongPasswords(t *testing.T) {
+	b := Bcrypt{Cost: BcryptMinCost}
+
+	// Two passwords that only differ after bcrypt's 72-byte limit.
+	password := strings.Repeat("a", 100) + "1"
+	other := strings.Repeat("a", 100) + "2"
+
+	hash, err := b.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := b.Matches(password, hash)
+	if err != nil || !ok {
+		t.Fatalf("password doesn't match its own hash: %v", err)
+	}
+
+	ok, err = b.Matches(other, hash)
+	if err != nil || ok {
+		t.Fatalf("a different password matched: %v", err)
+	}
+}
+
+// countingHasher counts how many times its hasher is asked to check a password.
+type countingHasher struct {
+	Hasher
+	matches int
+}
+
+func (c *countingHasher) Matches(plaintext string, encoded []byte) (bool, error) {
+	c.matches++
+	return c.Hasher.Matches(plaintext, encoded)
+}
+
+func TestSetVerify(t *testing.T) {
+	argon2id := &countingHasher{Hasher: Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}}
+	bcrypt := &countingHasher{Hasher: Bcrypt{Cost: BcryptMinCost}}
+	set := NewSet(argon2id, bcrypt)
+
+	argon2idHash, err := argon2id.Hash("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptHash, err := bcrypt.Hash("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		plaintext string
+		encoded   []byte
+		want      bool
+		wantErr   error
+	}{
+		{"default hasher", "pa55word1234", argon2idHash, true, nil},
+		{"default hasher, wrong password", "wrong", argon2idHash, false, nil},
+		{"legacy hasher", "pa55word1234", bcryptHash, true, nil},
+		{"legacy hasher, wrong password", "wrong", bcryptHash, false, nil},
+		{"no hash", "pa55word1234", nil, false, nil},
+		{"unknown algorithm", "pa55word1234", []byte("$md5$abc"), false, ErrUnknownAlgorithm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argon2id.matches, bcrypt.matches = 0, 0
+
+			got, err := set.Verify(tt.plaintext, tt.encoded)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, %v; want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+
+			// Every hasher runs once, whichever one the hash came from.
+			if argon2id.matches != 1 || bcrypt.matches != 1 {
+				t.Errorf("got %d argon2id and %d bcrypt checks; want 1 of each", argon2id.matches, bcrypt.matches)
+			}
+		})
+	}
+}
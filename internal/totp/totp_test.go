@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed from RFC 6238, appendix B.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists eight digit values; these are their low six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if got != tt.want {
+			t.Errorf("Code at %d: got %q; want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		code   string
+		want   bool
+		offset int64
+	}{
+		{"current step", codeForStep(rfcSecret, current), true, 0},
+		{"previous step", codeForStep(rfcSecret, current-1), true, -1},
+		{"next step", codeForStep(rfcSecret, current+1), true, 1},
+		{"two steps behind", codeForStep(rfcSecret, current-2), false, 0},
+		{"two steps ahead", codeForStep(rfcSecret, current+2), false, 0},
+		{"wrong code", "000000", false, 0},
+		{"too short", codeForStep(rfcSecret, current)[1:], false, 0},
+		{"too long", codeForStep(rfcSecret, current) + "0", false, 0},
+		{"eight digit RFC value", "14050471", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.want {
+				t.Fatalf("got ok %t; want %t", ok, tt.want)
+			}
+
+			if ok && step != current+tt.offset {
+				t.Errorf("got step %d; want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateOtherSecret(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	_, ok := Validate([]byte("another secret here!"), Code(rfcSecret, now), now)
+	if ok {
+		t.Error("accepted a code for a different secret")
+	}
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+                          id bigserial PRIMARY KEY,
+                          user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+                          name text NOT NULL,
+                          prefix text NOT NULL UNIQUE,
+                          hash bytea NOT NULL,
+                          permissions text[] NOT NULL DEFAULT '{}',
+                          created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+                          expiry timestamp(0) with time zone,
+                          last_used_at timestamp(0) with time zone,
+                          UNIQUE (user_id, name)
+);
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS totp_credentials (
+                                  user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+                                  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+                                  secret bytea NOT NULL,
+                                  confirmed bool NOT NULL DEFAULT false,
+                                  last_used_step bigint NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS recovery_codes (
+                                user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+                                hash bytea NOT NULL,
+                                PRIMARY KEY (user_id, hash)
+);
\ No newline at end of file
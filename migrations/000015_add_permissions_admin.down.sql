@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code IN ('permissions:admin', 'movies:*', '*');
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_code_key;
\ No newline at end of file
@@ -0,0 +1,2 @@
+INSERT INTO permissions (code)
+VALUES ('metrics:read');
\ No newline at end of file
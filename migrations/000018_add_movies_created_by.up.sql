@@ -0,0 +1,4 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS movies_created_by_idx ON movies (created_by);
+INSERT INTO permissions (code)
+VALUES ('movies:write:own');
\ No newline at end of file
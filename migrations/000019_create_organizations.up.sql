@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS organizations (
+                               id bigserial PRIMARY KEY,
+                               created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+                               name text NOT NULL
+);
+CREATE TABLE IF NOT EXISTS organization_members (
+                               organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+                               user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+                               role text NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+                               created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+                               PRIMARY KEY (organization_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON organization_members (user_id);
+-- Existing movies and users are moved into a single default organization, so that the
+-- catalogue looks the same as it did before organizations existed.
+INSERT INTO organizations (name)
+VALUES ('Default');
+INSERT INTO organization_members (organization_id, user_id, role)
+SELECT (SELECT min(id) FROM organizations), users.id, 'editor'
+FROM users;
+-- So that somebody can manage its members, user administrators become owners of the
+-- default organization, or failing that, its oldest member does.
+UPDATE organization_members
+SET role = 'owner'
+WHERE organization_id = (SELECT min(id) FROM organizations)
+AND user_id IN (
+    SELECT users.id
+    FROM users
+    WHERE users.deleted_at IS NULL
+    AND users.id IN (
+        SELECT users_permissions.user_id
+        FROM users_permissions
+        INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+        WHERE permissions.code IN ('users:admin', '*')
+        UNION
+        SELECT users_roles.user_id
+        FROM users_roles
+        INNER JOIN roles_permissions ON roles_permissions.role_id = users_roles.role_id
+        INNER JOIN permissions ON permissions.id = roles_permissions.permission_id
+        WHERE permissions.code IN ('users:admin', '*')
+    )
+);
+UPDATE organization_members
+SET role = 'owner'
+WHERE (organization_id, user_id) = (
+    SELECT organization_members.organization_id, organization_members.user_id
+    FROM organization_members
+    INNER JOIN users ON users.id = organization_members.user_id
+    WHERE organization_members.organization_id = (SELECT min(id) FROM organizations)
+    AND users.deleted_at IS NULL
+    AND NOT EXISTS (
+        SELECT 1 FROM organization_members AS owners
+        WHERE owners.organization_id = organization_members.organization_id AND owners.role = 'owner'
+    )
+    ORDER BY users.created_at, users.id
+    LIMIT 1
+);
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS organization_id bigint REFERENCES organizations ON DELETE CASCADE;
+UPDATE movies SET organization_id = (SELECT min(id) FROM organizations);
+ALTER TABLE movies ALTER COLUMN organization_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS movies_organization_id_idx ON movies (organization_id);
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS organization_invitations (
+                               id bigserial PRIMARY KEY,
+                               organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+                               email citext NOT NULL,
+                               role text NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+                               invited_by bigint REFERENCES users ON DELETE SET NULL,
+                               hash bytea NOT NULL UNIQUE,
+                               created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+                               expiry timestamp(0) with time zone NOT NULL,
+                               UNIQUE (organization_id, email)
+);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS last_used_user_agent;
+ALTER TABLE tokens DROP COLUMN IF EXISTS last_used_ip;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_ip text NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_user_agent text NOT NULL DEFAULT '';
+
+-- Each use used to overwrite client_ip and user_agent, so for tokens that have been used
+-- they hold the last client rather than the one the token was issued to.
+UPDATE tokens SET last_used_ip = client_ip, last_used_user_agent = user_agent WHERE last_used_at IS NOT NULL;
\ No newline at end of file